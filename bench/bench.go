@@ -0,0 +1,203 @@
+// Package bench measures the speed of the hash functions of every algorithm
+// on synthetic jobs, so it needs no node or network. It is used to size
+// hardware and to catch performance regressions in the hash backends.
+package bench
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/p9c/chainhash"
+	"github.com/p9c/fork"
+
+	blockchain "github.com/p9c/chain"
+
+	"github.com/p9c/kopach/kopachctrl/job"
+)
+
+// DefaultDuration is how long each algorithm is hashed for at each thread
+// count
+const DefaultDuration = time.Second * 5
+
+type Options struct {
+	// Height is the block height of the jobs, which selects the hard fork
+	// and so the algorithms
+	Height int32
+	// Threads is the thread counts to measure each algorithm at
+	Threads []int
+	// Duration is how long each measurement runs
+	Duration time.Duration
+	// Algos limits the measurements to the named algorithms, all of them
+	// if it is empty
+	Algos []string
+}
+
+// Result is the outcome of hashing one algorithm on a number of threads
+type Result struct {
+	Version int32
+	Name    string
+	Threads int
+	Hashes  uint64
+	Elapsed time.Duration
+}
+
+// Rate returns the hashes per second of the whole measurement
+func (r *Result) Rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Hashes) / r.Elapsed.Seconds()
+}
+
+// DefaultHeight returns the first height of the latest hard fork on the
+// current network
+func DefaultHeight() int32 {
+	last := fork.List[len(fork.List)-1]
+	if fork.IsTestnet {
+		return last.TestnetStart
+	}
+	return last.ActivationHeight
+}
+
+// Job returns a job for the given height with a random previous block and
+// merkle roots and the minimum difficulty of each algorithm, in the same
+// form as a controller sends
+func Job(height int32) (j *job.Job, err error) {
+	hf := fork.List[fork.GetCurrent(height)]
+	j = &job.Job{
+		Height:        height,
+		PrevBlockHash: &chainhash.Hash{},
+		Bitses:        make(blockchain.TargetBits),
+		Hashes:        make(map[int32]*chainhash.Hash),
+	}
+	if _, err = rand.Read(j.PrevBlockHash[:]); err != nil {
+		return
+	}
+	for ver, name := range hf.AlgoVers {
+		j.Bitses[ver] = hf.Algos[name].MinBits
+		h := &chainhash.Hash{}
+		if _, err = rand.Read(h[:]); err != nil {
+			return
+		}
+		j.Hashes[ver] = h
+	}
+	return
+}
+
+// Run measures each algorithm at each thread count in turn and returns the
+// results as they are completed. It stops early if quit is closed.
+func Run(o Options, quit chan struct{}) (results []Result, err error) {
+	if o.Duration <= 0 {
+		o.Duration = DefaultDuration
+	}
+	if len(o.Threads) < 1 {
+		o.Threads = []int{1}
+	}
+	var j *job.Job
+	if j, err = Job(o.Height); err != nil {
+		return
+	}
+	var versions []int32
+	for ver := range j.Bitses {
+		name := fork.GetAlgoName(ver, o.Height)
+		if len(o.Algos) > 0 && !contains(o.Algos, name) {
+			continue
+		}
+		versions = append(versions, ver)
+	}
+	if len(versions) < 1 {
+		err = fmt.Errorf("none of the algorithms %v are used at height %d",
+			o.Algos, o.Height)
+		return
+	}
+	sort.Slice(versions, func(i, k int) bool {
+		return versions[i] < versions[k]
+	})
+	for _, ver := range versions {
+		for _, threads := range o.Threads {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			results = append(results, measure(j, ver, threads, o.Duration,
+				quit))
+		}
+	}
+	return
+}
+
+// measure hashes one version on a number of goroutines for a duration
+func measure(j *job.Job, ver int32, threads int, d time.Duration,
+	quit chan struct{}) (r Result) {
+	r = Result{
+		Version: ver,
+		Name:    fork.GetAlgoName(ver, j.Height),
+		Threads: threads,
+	}
+	var stop atomic.Bool
+	var hashes atomic.Uint64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < threads; i++ {
+		mb := j.GetMsgBlock(ver)
+		mb.Header.Bits = j.Bitses[ver]
+		// each goroutine works on its own range of nonces
+		mb.Header.Nonce = uint32(i) << 24
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var count uint64
+			for !stop.Load() {
+				_ = mb.Header.BlockHashWithAlgos(j.Height)
+				mb.Header.Nonce++
+				count++
+			}
+			hashes.Add(count)
+		}()
+	}
+	select {
+	case <-time.After(d):
+	case <-quit:
+	}
+	stop.Store(true)
+	wg.Wait()
+	r.Elapsed = time.Since(start)
+	r.Hashes = hashes.Load()
+	return
+}
+
+// Print writes a table of the results with the hashes per second of each
+// measurement and of each of its threads
+func Print(w io.Writer, results []Result) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if _, err = fmt.Fprintln(tw,
+		"algorithm\tversion\tthreads\thashes/s\thashes/s/thread\t"); err != nil {
+		return
+	}
+	for i := range results {
+		r := &results[i]
+		if _, err = fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t\n", r.Name,
+			r.Version, r.Threads, r.Rate(),
+			r.Rate()/float64(r.Threads)); err != nil {
+			return
+		}
+	}
+	return tw.Flush()
+}
+
+func contains(names []string, name string) bool {
+	for i := range names {
+		if names[i] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// Package capture is the file format of recorded kopach LAN traffic. A
+// capture is a JSON object per line for each decrypted message, with the
+// time it arrived or was sent and where it came from, so it can be read back
+// by tools and by people alike.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MaxLine is the longest line a Reader accepts, big enough for a solution
+// carrying a full block
+const MaxLine = 1 << 24
+
+// Record is one message received or sent
+type Record struct {
+	Time time.Time `json:"time"`
+	// Source is the address the message came from
+	Source string `json:"source,omitempty"`
+	// Sent is set for messages sent by the recording machine rather than
+	// received by it
+	Sent bool `json:"sent,omitempty"`
+	// Data is the decrypted container, starting with its magic
+	Data []byte `json:"data"`
+}
+
+// Magic returns the magic of the message, or an empty string if it is too
+// short to have one
+func (r *Record) Magic() string {
+	if len(r.Data) < 4 {
+		return ""
+	}
+	return string(r.Data[:4])
+}
+
+// Writer writes records to a capture, it can be shared by several goroutines
+type Writer struct {
+	mx  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriter returns a Writer writing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Write adds a record to the capture
+func (w *Writer) Write(r *Record) (err error) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return w.enc.Encode(r)
+}
+
+// Reader reads the records of a capture in order
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader returns a Reader reading from r
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, MaxLine)
+	return &Reader{scanner: s}
+}
+
+// Next returns the next record, or io.EOF at the end of the capture. Empty
+// lines are skipped.
+func (r *Reader) Next() (rec Record, err error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if err = json.Unmarshal(line, &rec); err != nil {
+			return rec, &LineError{Line: r.line, Err: err}
+		}
+		return
+	}
+	if err = r.scanner.Err(); err == nil {
+		err = io.EOF
+	}
+	return
+}
+
+// LineError is a line of a capture that could not be read
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("capture line %d: %v", e.Line, e.Err)
+}
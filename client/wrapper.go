@@ -8,6 +8,8 @@ import (
 	log "github.com/p9c/logi"
 
 	"github.com/p9c/kopach/kopachctrl/job"
+	"github.com/p9c/kopach/worker"
+	"github.com/p9c/kopach/worker/event"
 )
 
 type Client struct {
@@ -56,6 +58,48 @@ func (c *Client) Pause() (err error) {
 	return
 }
 
+// SelfTest returns an error if the worker is not hashing for the network
+// mined, testnet or mainnet, or its hash functions failed their check against
+// the known answers
+func (c *Client) SelfTest(testnet bool) (err error) {
+	var reply bool
+	if err = c.Call("Worker.SelfTest", testnet, &reply); err != nil {
+		return
+	}
+	if reply != true {
+		err = errors.New("self test not acknowledged")
+	}
+	return
+}
+
+// Resume restarts work on the current job after a pause
+func (c *Client) Resume() (err error) {
+	var reply bool
+	err = c.Call("Worker.Resume", 1, &reply)
+	if err != nil {
+		log.L.Error(err)
+		return
+	}
+	if reply != true {
+		err = errors.New("resume command not acknowledged")
+	}
+	return
+}
+
+// Configure changes the settings of the worker
+func (c *Client) Configure(settings *worker.Settings) (err error) {
+	var reply bool
+	err = c.Call("Worker.Configure", settings, &reply)
+	if err != nil {
+		log.L.Error(err)
+		return
+	}
+	if reply != true {
+		err = errors.New("configure command not acknowledged")
+	}
+	return
+}
+
 func (c *Client) Stop() (err error) {
 	log.L.Debug("stop working (exit)")
 	var reply bool
@@ -83,3 +127,11 @@ func (c *Client) SendPass(pass string) (err error) {
 	}
 	return
 }
+
+// Events waits for the worker to report hashrate samples, solutions, errors
+// and state changes. Unlike the other calls this blocks until the worker has
+// something to say, so it should be called in a loop from its own goroutine.
+func (c *Client) Events() (events []event.Event, err error) {
+	err = c.Call("Worker.Events", event.MaxEvents, &events)
+	return
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/p9c/fork"
+)
+
+// AlgoNames returns the names of every algorithm in every hard fork, in order
+func AlgoNames() (out []string) {
+	seen := make(map[string]bool)
+	for i := range fork.List {
+		for _, name := range fork.List[i].AlgoVers {
+			if !seen[name] {
+				seen[name] = true
+				out = append(out, name)
+			}
+		}
+	}
+	sort.Strings(out)
+	return
+}
+
+// CheckAlgos returns an error naming any of the algorithms that are not known
+func CheckAlgos(names []string) (err error) {
+	known := make(map[string]bool)
+	for _, name := range AlgoNames() {
+		known[name] = true
+	}
+	var unknown []string
+	for _, name := range names {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		err = fmt.Errorf("unknown algorithms %s, the algorithms are %s",
+			strings.Join(unknown, " "), strings.Join(AlgoNames(), " "))
+	}
+	return
+}
+
+// EffectiveAlgos returns the algorithms the workers of this machine may mine
+// given the algorithms a controller allows, all of them if it is empty
+func (c *Config) EffectiveAlgos(allowed []string) (out []string) {
+	for _, name := range AlgoNames() {
+		if len(c.Algos) > 0 && !contains(c.Algos, name) {
+			continue
+		}
+		if len(allowed) > 0 && !contains(allowed, name) {
+			continue
+		}
+		if contains(c.DenyAlgos, name) {
+			continue
+		}
+		out = append(out, name)
+	}
+	return
+}
+
+func contains(names []string, name string) bool {
+	for i := range names {
+		if names[i] == name {
+			return true
+		}
+	}
+	return false
+}
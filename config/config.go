@@ -0,0 +1,172 @@
+// Package config holds the settings of a kopach miner that are not part of
+// the pod configuration. They are stored as JSON in the data directory and
+// the file is created with defaults on the first run.
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/p9c/logi"
+)
+
+// FileName is the name of the kopach configuration file in the data directory
+const FileName = "kopach.json"
+
+type Config struct {
+	// ID is a stable random identifier for this machine, generated on the
+	// first run so controllers can tell machines apart
+	ID string
+	// Name is an operator chosen label for this machine, it defaults to the
+	// host name
+	Name string
+	// ControlKey signs the settings controllers push to miners. Controllers
+	// need it to send settings and miners only accept settings if it is set.
+	ControlKey string
+	// Rotation is how workers share their time between algorithms, "rounds"
+	// gives each the same number of hashes, "time" the same time and
+	// "difficulty" time in proportion to the solutions expected from each
+	Rotation string
+	// RotationSlice is the time given to each algorithm when rotating by
+	// time, such as "1s"
+	RotationSlice string
+	// AlgoWeights scale the time slice of the named algorithms
+	AlgoWeights map[string]float64
+	// Algos is the names of the algorithms this machine may mine, all of
+	// them if it is empty, and DenyAlgos the names it may never mine. The
+	// algorithms controllers allow are limited to these.
+	Algos     []string
+	DenyAlgos []string
+	// ThreadsPerWorker is the most hashing threads one worker process runs.
+	// At 1, the default, every thread is its own process, and at the
+	// thread count all the threads run in one process.
+	ThreadsPerWorker int
+	// InProcess runs the workers as goroutines of the kopach process, talking
+	// over in-memory pipes, instead of as child processes. A crash in a
+	// worker then takes down the whole miner.
+	InProcess bool
+	// Record is a file the jobs and pauses received from controllers and
+	// the solutions and hashrate reports sent to them are appended to, to
+	// be played back by kopach replay. Nothing is recorded if it is empty.
+	Record string
+	// StatusListener is the address the controller of the node on this
+	// machine serves its status and events on over HTTP, such as
+	// 127.0.0.1:11050. They are not served if it is empty.
+	StatusListener string
+	// MetricsListener is the address kopach serves its metrics on over HTTP
+	// for Prometheus, at /metrics, such as 127.0.0.1:11051. They are not
+	// served if it is empty.
+	MetricsListener string
+}
+
+// Load reads the kopach configuration from the data directory, creating it
+// with a new machine ID if it does not exist yet
+func Load(dataDir string) (c *Config, err error) {
+	c = &Config{}
+	path := filepath.Join(dataDir, FileName)
+	var b []byte
+	if b, err = ioutil.ReadFile(path); err == nil {
+		if err = json.Unmarshal(b, c); err != nil {
+			log.L.Error(err)
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		log.L.Error(err)
+		return
+	}
+	err = nil
+	changed := false
+	if c.ID == "" {
+		id := make([]byte, 8)
+		if _, err = rand.Read(id); err != nil {
+			log.L.Error(err)
+			return
+		}
+		c.ID = hex.EncodeToString(id)
+		changed = true
+	}
+	if c.Name == "" {
+		if c.Name, err = os.Hostname(); err != nil {
+			c.Name, err = c.ID, nil
+		}
+		changed = true
+	}
+	if changed {
+		if err = c.Save(dataDir); err != nil {
+			return
+		}
+	}
+	err = c.Validate()
+	return
+}
+
+// Validate checks the rotation time slice, the weights and the algorithm
+// names, the rotation name is checked by the workers
+func (c *Config) Validate() (err error) {
+	if _, err = c.Slice(); err != nil {
+		return
+	}
+	if err = CheckAlgos(c.Algos); err != nil {
+		return
+	}
+	if err = CheckAlgos(c.DenyAlgos); err != nil {
+		return
+	}
+	if c.ThreadsPerWorker < 0 {
+		return fmt.Errorf("threads per worker %d is negative",
+			c.ThreadsPerWorker)
+	}
+	if len(c.EffectiveAlgos(nil)) < 1 {
+		return fmt.Errorf("no algorithms are left to mine after allowing %v"+
+			" and denying %v", c.Algos, c.DenyAlgos)
+	}
+	for name, weight := range c.AlgoWeights {
+		if err = CheckAlgos([]string{name}); err != nil {
+			return
+		}
+		if weight <= 0 {
+			return fmt.Errorf("weight of algorithm '%s' must be more than zero",
+				name)
+		}
+	}
+	return
+}
+
+// Slice returns the rotation time slice, zero for the worker default
+func (c *Config) Slice() (d time.Duration, err error) {
+	if c.RotationSlice == "" {
+		return
+	}
+	if d, err = time.ParseDuration(c.RotationSlice); err != nil {
+		return
+	}
+	if d <= 0 {
+		err = fmt.Errorf("rotation slice '%s' must be more than zero",
+			c.RotationSlice)
+	}
+	return
+}
+
+// Save writes the configuration to the data directory
+func (c *Config) Save(dataDir string) (err error) {
+	var b []byte
+	if b, err = json.MarshalIndent(c, "", "  "); err != nil {
+		log.L.Error(err)
+		return
+	}
+	if err = os.MkdirAll(dataDir, 0700); err != nil {
+		log.L.Error(err)
+		return
+	}
+	if err = ioutil.WriteFile(filepath.Join(dataDir, FileName), b,
+		0600); err != nil {
+		log.L.Error(err)
+	}
+	return
+}
@@ -7,6 +7,7 @@ require (
 	github.com/p9c/chain v0.0.27
 	github.com/p9c/chaincfg v0.0.5
 	github.com/p9c/chainhash v0.0.2
+	github.com/p9c/fec v0.0.2
 	github.com/p9c/fork v0.0.2
 	github.com/p9c/logi v0.0.13
 	github.com/p9c/pod v0.2.22
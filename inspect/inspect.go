@@ -0,0 +1,307 @@
+// Package inspect decodes the messages of the kopach LAN protocol for people
+// to read, as text or as JSON, either as they arrive from the multicast group
+// or from a capture recorded earlier.
+package inspect
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/p9c/logi"
+	"github.com/p9c/simplebuffer/Bitses"
+	"github.com/p9c/simplebuffer/Block"
+	"github.com/p9c/simplebuffer/Hash"
+	"github.com/p9c/simplebuffer/Hashes"
+	"github.com/p9c/simplebuffer/IPs"
+	"github.com/p9c/simplebuffer/Int32"
+	"github.com/p9c/simplebuffer/Time"
+	"github.com/p9c/simplebuffer/Uint16"
+	"github.com/p9c/transport"
+
+	"github.com/p9c/kopach/capture"
+	"github.com/p9c/kopach/kopachctrl"
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+	"github.com/p9c/kopach/kopachctrl/hashrate"
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/kopachctrl/identity"
+	"github.com/p9c/kopach/kopachctrl/job"
+	"github.com/p9c/kopach/kopachctrl/message"
+	"github.com/p9c/kopach/kopachctrl/p2padvt"
+	"github.com/p9c/kopach/kopachctrl/pause"
+	"github.com/p9c/kopach/kopachctrl/protocol"
+	"github.com/p9c/kopach/kopachctrl/settings"
+	"github.com/p9c/kopach/kopachctrl/sol"
+	"github.com/p9c/kopach/simplebuffer/String"
+	"github.com/p9c/kopach/simplebuffer/Strings"
+	"github.com/p9c/kopach/simplebuffer/validate"
+)
+
+// The output formats
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// TimeFormat is the layout of the arrival time of messages in text output
+const TimeFormat = "15:04:05.000"
+
+// Field is a decoded field of a message
+type Field struct {
+	Name  string      `json:"name"`
+	Kind  string      `json:"kind"`
+	Value interface{} `json:"value"`
+}
+
+// Message is a decoded message
+type Message struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source,omitempty"`
+	Sent   bool      `json:"sent,omitempty"`
+	Magic  string    `json:"magic"`
+	// Type is the name of the message type, empty when it is not known
+	Type string `json:"type,omitempty"`
+	// Version is the protocol version of the sender
+	Version byte    `json:"version"`
+	Size    int     `json:"size"`
+	Fields  []Field `json:"fields,omitempty"`
+	// Error is why the message could not be decoded
+	Error string `json:"error,omitempty"`
+	data  []byte
+}
+
+// Options are the settings of Listen
+type Options struct {
+	// Pass is the miner password the messages are encrypted with
+	Pass string
+	// Format is Text or JSON
+	Format string
+	// Recorder is given every message received if it is not nil
+	Recorder *capture.Writer
+	// Transport joins the group, UDP multicast if it is nil
+	Transport broadcast.Opener
+}
+
+// Dissect decodes a recorded message
+func Dissect(r *capture.Record) (m *Message) {
+	m = &Message{Time: r.Time, Source: r.Source, Sent: r.Sent,
+		Magic: r.Magic(), Size: len(r.Data), data: r.Data}
+	s, ok := message.Lookup([]byte(m.Magic))
+	if !ok {
+		m.Error = "unknown message type"
+		return
+	}
+	m.Type = s.Name
+	var err error
+	if m.Version, err = s.Check(r.Data); err != nil {
+		m.Error = err.Error()
+		return
+	}
+	var fields [][]byte
+	if fields, err = validate.Split(r.Data, s.Magic); err != nil {
+		m.Error = err.Error()
+		return
+	}
+	for i := range fields {
+		f := Field{Name: fmt.Sprint("unknown ", i+1), Kind: "unknown"}
+		switch {
+		case s.Signed && i == len(fields)-1:
+			f.Name, f.Kind = "Signature", protocol.Signature.Name
+		case i < len(s.Fields):
+			f.Name, f.Kind = s.Fields[i].Name, s.Fields[i].Kind.Name
+		}
+		f.Value = decode(f.Kind, fields[i])
+		m.Fields = append(m.Fields, f)
+	}
+	return
+}
+
+// header is a block header as it is shown
+type header struct {
+	Version    int32     `json:"version"`
+	PrevBlock  string    `json:"prevBlock"`
+	MerkleRoot string    `json:"merkleRoot"`
+	Timestamp  time.Time `json:"timestamp"`
+	Bits       string    `json:"bits"`
+	Nonce      uint32    `json:"nonce"`
+}
+
+// decode returns the value of a field of a kind that has been checked
+func decode(kind string, b []byte) interface{} {
+	switch kind {
+	case protocol.IPs.Name:
+		var out []string
+		for _, ip := range IPs.New().DecodeOne(b).Get() {
+			out = append(out, ip.String())
+		}
+		return out
+	case protocol.Uint16.Name:
+		return Uint16.New().DecodeOne(b).Get()
+	case protocol.Int32.Name:
+		return Int32.New().DecodeOne(b).Get()
+	case protocol.Time.Name:
+		return Time.New().DecodeOne(b).Get()
+	case protocol.Hash.Name:
+		return Hash.New().DecodeOne(b).Get().String()
+	case protocol.Bitses.Name:
+		out := make(map[int32]string)
+		for ver, bits := range Bitses.NewBitses().DecodeOne(b).Get() {
+			out[ver] = fmt.Sprintf("%08x", bits)
+		}
+		return out
+	case protocol.Hashes.Name:
+		out := make(map[int32]string)
+		for ver, h := range Hashes.NewHashes().DecodeOne(b).Get() {
+			out[ver] = h.String()
+		}
+		return out
+	case protocol.Block.Name:
+		h := Block.New().DecodeOne(b).Get().Header
+		return header{
+			Version:    h.Version,
+			PrevBlock:  h.PrevBlock.String(),
+			MerkleRoot: h.MerkleRoot.String(),
+			Timestamp:  h.Timestamp,
+			Bits:       fmt.Sprintf("%08x", h.Bits),
+			Nonce:      h.Nonce,
+		}
+	case protocol.String.Name:
+		return String.New().DecodeOne(b).Get()
+	case protocol.Strings.Name:
+		return Strings.New().DecodeOne(b).Get()
+	case protocol.Counts.Name:
+		return hashrate.NewCounts().DecodeOne(b).Get()
+	case protocol.Identity.Name:
+		return identity.New().DecodeOne(b).Get()
+	case protocol.Protocol.Name:
+		return b[0]
+	case protocol.Signature.Name:
+		return hex.EncodeToString(String.New().DecodeOne(b).Bytes)
+	}
+	return hex.EncodeToString(b)
+}
+
+// String returns the message as text, using the String method of its
+// container
+func (m *Message) String() (s string) {
+	s = m.Time.Format(TimeFormat)
+	if m.Type != "" {
+		s += " " + m.Type
+	} else {
+		s += fmt.Sprintf(" %q", m.Magic)
+	}
+	s += fmt.Sprintf(" protocol %d", m.Version)
+	if m.Sent {
+		s += " sent"
+	}
+	if m.Source != "" {
+		s += " from " + m.Source
+	}
+	s += fmt.Sprintf(" %d bytes", m.Size)
+	if m.Error != "" {
+		return s + "\n  " + m.Error + "\n"
+	}
+	switch m.Magic {
+	case string(job.Magic):
+		j := job.LoadContainer(m.data)
+		s += j.String()
+	case string(sol.SolutionMagic):
+		s += sol.LoadSolContainer(m.data).String()
+	case string(pause.PauseMagic):
+		s += pause.LoadPauseContainer(m.data).String()
+	case string(p2padvt.Magic):
+		a := p2padvt.LoadContainer(m.data)
+		s += a.String()
+	case string(hashrate.HashrateMagic):
+		h := hashrate.LoadContainer(m.data)
+		s += h.String()
+	case string(heartbeat.Magic):
+		h := heartbeat.LoadContainer(m.data)
+		s += h.String()
+	case string(settings.Magic):
+		c := settings.LoadContainer(m.data)
+		s += c.String()
+	}
+	return
+}
+
+// Print writes a message in the format
+func Print(w io.Writer, m *Message, format string) (err error) {
+	switch format {
+	case JSON:
+		var b []byte
+		if b, err = json.Marshal(m); err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+	case Text, "":
+		_, err = fmt.Fprintln(w, m.String())
+	default:
+		err = fmt.Errorf("unknown format '%s'", format)
+	}
+	return
+}
+
+// Listen joins the group with the miner password and prints every message
+// received until quit is closed
+func Listen(o Options, w io.Writer, quit chan struct{}) (err error) {
+	if o.Format != Text && o.Format != JSON && o.Format != "" {
+		return fmt.Errorf("unknown format '%s'", o.Format)
+	}
+	open := o.Transport
+	if open == nil {
+		open = broadcast.Multicast(transport.DefaultPort,
+			kopachctrl.MaxDatagramSize)
+	}
+	var mx sync.Mutex
+	handle := func(ctx interface{}, src net.Addr, dst string,
+		b []byte) (err error) {
+		r := &capture.Record{Time: time.Now(),
+			Data: append([]byte{}, b...)}
+		if src != nil {
+			r.Source = src.String()
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		if o.Recorder != nil {
+			if err = o.Recorder.Write(r); err != nil {
+				return
+			}
+		}
+		return Print(w, Dissect(r), o.Format)
+	}
+	handlers := make(transport.Handlers)
+	for _, s := range message.Schemas {
+		handlers[string(s.Magic)] = handle
+	}
+	var conn broadcast.Channel
+	if conn, err = open("inspect", nil, o.Pass, handlers, quit); err != nil {
+		return
+	}
+	<-quit
+	if err := conn.Close(); err != nil {
+		log.L.Error(err)
+	}
+	return
+}
+
+// Read prints every message of a capture
+func Read(r io.Reader, format string, w io.Writer) (err error) {
+	cr := capture.NewReader(r)
+	for {
+		var rec capture.Record
+		if rec, err = cr.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if err = Print(w, Dissect(&rec), format); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package kopach_bench
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/chaincfg/netparams"
+	"github.com/p9c/fork"
+	"github.com/p9c/pod/pkg/conte"
+	"github.com/p9c/util/interrupt"
+
+	"github.com/p9c/kopach/bench"
+	"github.com/p9c/kopach/config"
+)
+
+// Flags are the options of the bench subcommand
+var Flags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "height",
+		Usage: "block height of the jobs, the start of the latest hard fork if zero",
+	},
+	cli.StringFlag{
+		Name:  "threads",
+		Usage: "comma separated thread counts to measure, 1 and the number of CPUs if empty",
+	},
+	cli.DurationFlag{
+		Name:  "duration",
+		Usage: "how long to hash each algorithm at each thread count",
+		Value: bench.DefaultDuration,
+	},
+	cli.StringFlag{
+		Name:  "algos",
+		Usage: "comma separated names of the algorithms to measure, all of them if empty",
+	},
+}
+
+// KopachBenchHandle measures the hash speed of every algorithm on synthetic
+// jobs for the active network and prints a table of the results. It needs
+// no node or network. It is the bench subcommand of kopach, with Flags.
+func KopachBenchHandle(cx *conte.Xt) func(c *cli.Context) error {
+	return func(c *cli.Context) (err error) {
+		if cx.ActiveNet.Name == netparams.TestNet3Params.Name {
+			fork.IsTestnet = true
+		}
+		o := bench.Options{
+			Height:   int32(c.Int("height")),
+			Duration: c.Duration("duration"),
+		}
+		if o.Height == 0 {
+			o.Height = bench.DefaultHeight()
+		}
+		if o.Threads, err = parseThreads(c.String("threads")); err != nil {
+			return
+		}
+		if a := c.String("algos"); a != "" {
+			o.Algos = strings.Split(a, ",")
+			if err = config.CheckAlgos(o.Algos); err != nil {
+				return
+			}
+		}
+		quit := make(chan struct{})
+		interrupt.AddHandler(func() {
+			log.L.Debug("KopachBenchHandle interrupt")
+			close(quit)
+		})
+		fmt.Println("benchmarking", cx.ActiveNet.Name, "at height", o.Height,
+			"for", o.Duration, "per measurement")
+		var results []bench.Result
+		if results, err = bench.Run(o, quit); err != nil {
+			return
+		}
+		return bench.Print(os.Stdout, results)
+	}
+}
+
+// parseThreads reads a comma separated list of thread counts
+func parseThreads(s string) (threads []int, err error) {
+	if s == "" {
+		threads = []int{1}
+		if n := runtime.NumCPU(); n > 1 {
+			threads = append(threads, n)
+		}
+		return
+	}
+	for _, f := range strings.Split(s, ",") {
+		var n int
+		if n, err = strconv.Atoi(strings.TrimSpace(f)); err != nil {
+			return
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("thread count %d is less than 1", n)
+		}
+		threads = append(threads, n)
+	}
+	return
+}
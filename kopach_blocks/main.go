@@ -0,0 +1,74 @@
+package kopach_blocks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/p9c/pod/pkg/conte"
+
+	"github.com/p9c/kopach/kopachctrl/history"
+)
+
+// The output formats
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Flags are the options of the blocks subcommand
+var Flags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "file",
+		Usage: "block history to read, the one of the active network in the data directory if empty",
+	},
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "output format, text or json",
+		Value: Text,
+	},
+	cli.IntFlag{
+		Name:  "latest",
+		Usage: "number of the latest blocks to list, all of them if negative",
+		Value: 10,
+	},
+}
+
+// KopachBlocksHandle reports on the blocks found by the miners of the
+// controller of the node, with totals by algorithm and by machine. It is the
+// blocks subcommand of kopach, with Flags.
+func KopachBlocksHandle(cx *conte.Xt) func(c *cli.Context) error {
+	return func(c *cli.Context) (err error) {
+		path := c.String("file")
+		if path == "" {
+			path = history.Path(*cx.Config.DataDir, cx.ActiveNet.Name)
+		}
+		var blocks []history.Block
+		if blocks, err = history.ReadFile(path); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no blocks have been recorded in %s", path)
+			}
+			return
+		}
+		r := history.Summarize(blocks)
+		switch c.String("format") {
+		case JSON:
+			latest := c.Int("latest")
+			if latest < 0 || latest > len(blocks) {
+				latest = len(blocks)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(struct {
+				history.Report
+				Latest []history.Block `json:"latest"`
+			}{r, blocks[len(blocks)-latest:]})
+		case Text, "":
+			return history.Print(os.Stdout, r, blocks, c.Int("latest"))
+		default:
+			return fmt.Errorf("unknown format '%s'", c.String("format"))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package kopach_inspect
+
+import (
+	"os"
+
+	"github.com/urfave/cli"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/chaincfg/netparams"
+	"github.com/p9c/fork"
+	"github.com/p9c/pod/pkg/conte"
+	"github.com/p9c/util/interrupt"
+
+	"github.com/p9c/kopach/capture"
+	"github.com/p9c/kopach/inspect"
+)
+
+// Flags are the options of the inspect subcommand
+var Flags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "pass",
+		Usage: "miner password of the LAN, the configured one if empty",
+	},
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "output format, text or json",
+		Value: inspect.Text,
+	},
+	cli.StringFlag{
+		Name:  "record",
+		Usage: "file to record the messages received to",
+	},
+	cli.StringFlag{
+		Name:  "read",
+		Usage: "recorded file to decode instead of listening",
+	},
+}
+
+// KopachInspectHandle joins the multicast group of the miners and prints
+// every message sent on it, optionally recording them, or decodes a
+// recording. It is the inspect subcommand of kopach, with Flags.
+func KopachInspectHandle(cx *conte.Xt) func(c *cli.Context) error {
+	return func(c *cli.Context) (err error) {
+		if cx.ActiveNet.Name == netparams.TestNet3Params.Name {
+			fork.IsTestnet = true
+		}
+		if path := c.String("read"); path != "" {
+			var f *os.File
+			if f, err = os.Open(path); err != nil {
+				return
+			}
+			defer func() {
+				if err := f.Close(); err != nil {
+					log.L.Error(err)
+				}
+			}()
+			return inspect.Read(f, c.String("format"), os.Stdout)
+		}
+		o := inspect.Options{
+			Pass:   c.String("pass"),
+			Format: c.String("format"),
+		}
+		if o.Pass == "" {
+			o.Pass = *cx.Config.MinerPass
+		}
+		if path := c.String("record"); path != "" {
+			var f *os.File
+			if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|
+				os.O_APPEND, 0600); err != nil {
+				return
+			}
+			defer func() {
+				if err := f.Close(); err != nil {
+					log.L.Error(err)
+				}
+			}()
+			o.Recorder = capture.NewWriter(f)
+		}
+		quit := make(chan struct{})
+		interrupt.AddHandler(func() {
+			log.L.Debug("KopachInspectHandle interrupt")
+			close(quit)
+		})
+		return inspect.Listen(o, os.Stdout, quit)
+	}
+}
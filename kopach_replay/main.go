@@ -0,0 +1,124 @@
+package kopach_replay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/chaincfg/netparams"
+	"github.com/p9c/fork"
+	"github.com/p9c/pod/pkg/conte"
+	"github.com/p9c/util/interrupt"
+
+	"github.com/p9c/kopach"
+	"github.com/p9c/kopach/capture"
+	"github.com/p9c/kopach/replay"
+)
+
+// Flags are the options of the replay subcommand
+var Flags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "read",
+		Usage: "recorded file to play back",
+	},
+	cli.Float64Flag{
+		Name:  "speed",
+		Usage: "how many times faster than recorded to play, 0 for no waiting",
+		Value: 1,
+	},
+	cli.IntFlag{
+		Name:  "threads",
+		Usage: "hashing threads to mine the jobs with, the configured number if zero",
+	},
+	cli.DurationFlag{
+		Name:  "linger",
+		Usage: "how long to keep mining after the last message is played",
+		Value: time.Second * 3,
+	},
+	cli.StringFlag{
+		Name:  "sent",
+		Usage: "file to record the solutions and hashrate reports sent to",
+	},
+}
+
+// KopachReplayHandle runs kopach on a recording made with the Record option
+// of the kopach configuration or by kopach inspect instead of the LAN,
+// playing the jobs and pauses to the workers as they were received. It is
+// the replay subcommand of kopach, with Flags.
+func KopachReplayHandle(cx *conte.Xt) func(c *cli.Context) error {
+	return func(c *cli.Context) (err error) {
+		if cx.ActiveNet.Name == netparams.TestNet3Params.Name {
+			fork.IsTestnet = true
+		}
+		path := c.String("read")
+		if path == "" {
+			return errors.New("no recording given to play")
+		}
+		if c.Float64("speed") < 0 {
+			return fmt.Errorf("speed %v is negative", c.Float64("speed"))
+		}
+		var records []capture.Record
+		var f *os.File
+		if f, err = os.Open(path); err != nil {
+			return
+		}
+		records, err = replay.Load(f)
+		if err := f.Close(); err != nil {
+			log.L.Error(err)
+		}
+		if err != nil {
+			return
+		}
+		o := replay.Options{Speed: c.Float64("speed")}
+		if sent := c.String("sent"); sent != "" {
+			var out *os.File
+			if out, err = os.OpenFile(sent, os.O_CREATE|os.O_WRONLY|
+				os.O_APPEND, 0600); err != nil {
+				return
+			}
+			defer func() {
+				if err := out.Close(); err != nil {
+					log.L.Error(err)
+				}
+			}()
+			o.Sent = capture.NewWriter(out)
+		}
+		threads := c.Int("threads")
+		if threads < 1 {
+			threads = *cx.Config.GenThreads
+		}
+		session := replay.NewSession(records, o)
+		var m *kopach.Miner
+		if m, err = kopach.NewMiner(kopach.Options{
+			DataDir:   *cx.Config.DataDir,
+			Network:   cx.ActiveNet.Name,
+			Pass:      *cx.Config.MinerPass,
+			Threads:   threads,
+			LogLevel:  *cx.Config.LogLevel,
+			InProcess: true,
+			Transport: session.Open,
+		}); err != nil {
+			return
+		}
+		if err = m.Start(); err != nil {
+			return
+		}
+		defer m.Stop()
+		interrupt.AddHandler(func() {
+			log.L.Debug("KopachReplayHandle interrupt")
+			m.Stop()
+		})
+		fmt.Println("playing", len(records), "recorded messages from", path)
+		played := session.Wait()
+		time.Sleep(c.Duration("linger"))
+		s := m.Stats()
+		fmt.Println("played", played, "messages,", s.Solutions,
+			"solutions found")
+		return
+	}
+}
@@ -0,0 +1,93 @@
+package kopach_sim
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/pod/pkg/conte"
+	"github.com/p9c/util/interrupt"
+
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+	"github.com/p9c/kopach/sim"
+)
+
+// Flags are the options of the sim subcommand
+var Flags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "blocks",
+		Usage: "number of blocks to mine",
+		Value: sim.DefaultBlocks,
+	},
+	cli.IntFlag{
+		Name:  "miners",
+		Usage: "number of kopach machines mining",
+		Value: 1,
+	},
+	cli.IntFlag{
+		Name:  "threads",
+		Usage: "hashing threads of each machine",
+		Value: 1,
+	},
+	cli.Float64Flag{
+		Name:  "loss",
+		Usage: "chance of dropping each message shard",
+	},
+	cli.Float64Flag{
+		Name:  "duplicate",
+		Usage: "chance of delivering each message shard twice",
+	},
+	cli.DurationFlag{
+		Name:  "delay",
+		Usage: "delay added to every message shard",
+	},
+	cli.DurationFlag{
+		Name:  "jitter",
+		Usage: "most random delay added to every message shard",
+	},
+	cli.Int64Flag{
+		Name:  "seed",
+		Usage: "seed of the random faults",
+	},
+	cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "how long the blocks may take",
+		Value: sim.DefaultTimeout,
+	},
+}
+
+// KopachSimHandle mines a few blocks offline with a controller and miners in
+// this process, checks every block and prints them. It needs no node or
+// network. It is the sim subcommand of kopach, with Flags.
+func KopachSimHandle(cx *conte.Xt) func(c *cli.Context) error {
+	return func(c *cli.Context) (err error) {
+		o := sim.Options{
+			Blocks:  c.Int("blocks"),
+			Miners:  c.Int("miners"),
+			Threads: c.Int("threads"),
+			Faults: broadcast.Faults{
+				Loss:      c.Float64("loss"),
+				Duplicate: c.Float64("duplicate"),
+				Delay:     c.Duration("delay"),
+				Jitter:    c.Duration("jitter"),
+			},
+			Seed:    c.Int64("seed"),
+			Timeout: c.Duration("timeout"),
+		}
+		quit := make(chan struct{})
+		interrupt.AddHandler(func() {
+			log.L.Debug("KopachSimHandle interrupt")
+			close(quit)
+		})
+		fmt.Println("mining", o.Blocks, "blocks with", o.Miners,
+			"miners of", o.Threads, "threads")
+		var r *sim.Result
+		if r, err = sim.Run(o, quit); err != nil {
+			return
+		}
+		return sim.Print(os.Stdout, r)
+	}
+}
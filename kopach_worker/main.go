@@ -9,7 +9,6 @@ import (
 	log "github.com/p9c/logi"
 
 	"github.com/p9c/kopach/worker"
-	"github.com/p9c/chaincfg/netparams"
 	"github.com/p9c/fork"
 	"github.com/p9c/pod/pkg/conte"
 	"github.com/p9c/util/interrupt"
@@ -29,7 +28,7 @@ func KopachWorkerHandle(cx *conte.Xt) func(c *cli.Context) error {
 		// testnet probably never as high as this and hard fork activates early
 		// for testing as pre-hardfork doesn't need testing or CPU mining.
 		if len(os.Args) > 2 {
-			if os.Args[2] == netparams.TestNet3Params.Name {
+			if worker.Testnet(os.Args[2]) {
 				fork.IsTestnet = true
 			}
 		}
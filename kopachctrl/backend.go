@@ -0,0 +1,93 @@
+package kopachctrl
+
+import (
+	"net"
+	"time"
+
+	"github.com/p9c/chaincfg/netparams"
+	"github.com/p9c/util"
+
+	blockchain "github.com/p9c/chain"
+	"github.com/p9c/chain/mining"
+
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+)
+
+// TemplateSource makes the block templates the jobs are made from
+type TemplateSource interface {
+	// NewBlockTemplate returns a template for the next block paying to one
+	// of the mining addresses
+	NewBlockTemplate() (*mining.BlockTemplate, error)
+	// TxLastUpdated is the last time the transactions available for a
+	// template changed
+	TxLastUpdated() time.Time
+}
+
+// ChainState tells the controller about the best chain
+type ChainState interface {
+	// BestSnapshot returns the state of the tip of the best chain
+	BestSnapshot() *blockchain.BestState
+	// IsCurrent is true when the chain is synced and mining is worthwhile
+	IsCurrent() bool
+	// Subscribe registers a callback for chain notifications
+	Subscribe(callback blockchain.NotificationCallback)
+}
+
+// DifficultyCalculator gives the targets of the next block
+type DifficultyCalculator interface {
+	// NextTargets returns the difficulty bits of each block version for
+	// the block after the tip of the best chain
+	NextTargets() (blockchain.TargetBits, error)
+}
+
+// BlockSubmitter takes the blocks solved by the miners
+type BlockSubmitter interface {
+	// ProcessBlock validates a block and connects it to the chain
+	ProcessBlock(block *util.Block) (isOrphan bool, err error)
+	// BlockByHeight returns the block at a height of the best chain
+	BlockByHeight(height int32) (*util.Block, error)
+}
+
+// PeerConnector connects the node to the other nodes found on the LAN
+type PeerConnector interface {
+	// Connect adds a permanent peer
+	Connect(addr string) error
+	// SetOtherNodes records how many other nodes are on the LAN
+	SetOtherNodes(n int32)
+}
+
+// Backend is the node the controller makes jobs for
+type Backend struct {
+	Templates  TemplateSource
+	Chain      ChainState
+	Difficulty DifficultyCalculator
+	Blocks     BlockSubmitter
+	Peers      PeerConnector
+}
+
+// Config is the settings of a controller
+type Config struct {
+	// Params are the parameters of the network mined
+	Params *netparams.Params
+	// Pass is the miner password shared with the miners and ControlKey
+	// signs the settings pushed to them
+	Pass       string
+	ControlKey string
+	// P2PListener and RPCListener are the addresses of the node advertised
+	// to the other nodes on the LAN, and Controller the address solutions
+	// are sent to
+	P2PListener string
+	RPCListener string
+	Controller  string
+	// IPs are the addresses of the controller put in its messages, the
+	// routable addresses of the host if it is empty
+	IPs []*net.IP
+	// Transport joins the group of miners, UDP multicast if it is nil
+	Transport broadcast.Opener
+	// StatusListener is the address the status API is served on, such as
+	// 127.0.0.1:11050, it is not served if it is empty
+	StatusListener string
+	// BlocksFile is where the blocks found by the miners are recorded, none
+	// are if it is empty
+	BlocksFile string
+}
@@ -0,0 +1,36 @@
+// Package broadcast is how the controllers, kopach machines and workers on a
+// LAN send messages to each other. Messages go to every member of the group
+// with the same key, and the messages received are passed to the handler of
+// their magic.
+package broadcast
+
+import (
+	"github.com/p9c/transport"
+)
+
+// Channel sends messages to the group
+type Channel interface {
+	// SendMany sends a message as the shards produced by transport.GetShards
+	SendMany(magic []byte, shards [][]byte) error
+	// Close stops sending
+	Close() error
+}
+
+// Opener joins the group, passing the messages for the handlers to them
+// with ctx until quit is closed. Only members with the same key can read
+// each other's messages.
+type Opener func(creator string, ctx interface{}, key string,
+	handlers transport.Handlers, quit chan struct{}) (Channel, error)
+
+// Multicast returns an Opener joining the UDP multicast group on a port
+func Multicast(port, maxDatagramSize int) Opener {
+	return func(creator string, ctx interface{}, key string,
+		handlers transport.Handlers, quit chan struct{}) (Channel, error) {
+		c, err := transport.NewBroadcastChannel(creator, ctx, key, port,
+			maxDatagramSize, handlers, quit)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
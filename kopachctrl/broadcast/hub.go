@@ -0,0 +1,245 @@
+package broadcast
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/p9c/fec"
+	log "github.com/p9c/logi"
+	"github.com/p9c/transport"
+)
+
+const (
+	// QueueSize is how many shards a hub member holds before it drops them,
+	// like a full socket buffer
+	QueueSize = 1024
+	// RequiredShards is how many shards of a message are needed to decode
+	// it, as for the multicast channel
+	RequiredShards = 3
+	// keptMessages is how many messages a hub member keeps shards of
+	keptMessages = 256
+)
+
+var errClosed = errors.New("channel is closed")
+
+// Faults are the faults a hub injects into every shard it delivers
+type Faults struct {
+	// Loss is the chance a shard is dropped
+	Loss float64
+	// Duplicate is the chance a shard is delivered twice
+	Duplicate float64
+	// Delay is added to every shard and a random amount up to Jitter more
+	Delay  time.Duration
+	Jitter time.Duration
+}
+
+// Hub is an in-memory group, so all the members of a LAN can run in one
+// process without a network
+type Hub struct {
+	mx      sync.Mutex
+	members map[*member]struct{}
+	faults  Faults
+	rand    *rand.Rand
+	nonce   uint64
+	next    int
+}
+
+// NewHub creates an empty hub, seed makes its faults repeatable
+func NewHub(seed int64) *Hub {
+	return &Hub{
+		members: make(map[*member]struct{}),
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SetFaults changes the faults injected into the shards sent after it
+func (h *Hub) SetFaults(f Faults) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.faults = f
+}
+
+// Open is an Opener joining the hub
+func (h *Hub) Open(creator string, ctx interface{}, key string,
+	handlers transport.Handlers, quit chan struct{}) (Channel, error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.next++
+	m := &member{
+		hub:      h,
+		creator:  creator,
+		ctx:      ctx,
+		key:      key,
+		handlers: handlers,
+		addr: &net.UDPAddr{IP: net.IPv4(127, 0, byte(h.next>>8),
+			byte(h.next)), Port: transport.DefaultPort},
+		queue:   make(chan packet, QueueSize),
+		quit:    quit,
+		closed:  make(chan struct{}),
+		buffers: make(map[uint64]*buffer),
+	}
+	h.members[m] = struct{}{}
+	go m.run()
+	return m, nil
+}
+
+// send delivers the shards of a message to all the members with the key
+func (h *Hub) send(from *member, magic []byte, shards [][]byte) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.nonce++
+	for m := range h.members {
+		if m.key != from.key {
+			continue
+		}
+		for _, shard := range shards {
+			if h.rand.Float64() < h.faults.Loss {
+				continue
+			}
+			copies := 1
+			if h.rand.Float64() < h.faults.Duplicate {
+				copies++
+			}
+			p := packet{magic: string(magic), nonce: h.nonce,
+				shard: append([]byte{}, shard...), src: from.addr}
+			for i := 0; i < copies; i++ {
+				delay := h.faults.Delay
+				if h.faults.Jitter > 0 {
+					delay += time.Duration(h.rand.Int63n(int64(h.faults.
+						Jitter)))
+				}
+				m.deliver(p, delay)
+			}
+		}
+	}
+}
+
+func (h *Hub) remove(m *member) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	delete(h.members, m)
+}
+
+type packet struct {
+	magic string
+	nonce uint64
+	shard []byte
+	src   net.Addr
+}
+
+type buffer struct {
+	shards  [][]byte
+	decoded bool
+}
+
+// has returns whether a shard number has already arrived. The decoder
+// panics when it is given the same shard twice, so duplicates are dropped.
+func (b *buffer) has(number byte) bool {
+	for _, s := range b.shards {
+		if s[0] == number {
+			return true
+		}
+	}
+	return false
+}
+
+// member is a Channel of a hub
+type member struct {
+	hub       *Hub
+	creator   string
+	ctx       interface{}
+	key       string
+	handlers  transport.Handlers
+	addr      *net.UDPAddr
+	queue     chan packet
+	quit      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	// buffers are only used by run
+	buffers map[uint64]*buffer
+}
+
+func (m *member) SendMany(magic []byte, shards [][]byte) (err error) {
+	select {
+	case <-m.closed:
+		return errClosed
+	default:
+	}
+	m.hub.send(m, magic, shards)
+	return
+}
+
+func (m *member) Close() (err error) {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		m.hub.remove(m)
+	})
+	return
+}
+
+// deliver queues a shard after a delay, dropping it if the queue is full
+func (m *member) deliver(p packet, delay time.Duration) {
+	put := func() {
+		select {
+		case m.queue <- p:
+		default:
+			log.L.Trace(m.creator, "dropped a shard, queue is full")
+		}
+	}
+	if delay <= 0 {
+		put()
+		return
+	}
+	time.AfterFunc(delay, put)
+}
+
+// run collects the shards of each message and gives the message to its
+// handler once enough have arrived to decode it
+func (m *member) run() {
+	address := fmt.Sprint("hub ", m.addr)
+	for {
+		var p packet
+		select {
+		case p = <-m.queue:
+		case <-m.quit:
+			_ = m.Close()
+			return
+		case <-m.closed:
+			return
+		}
+		handler, ok := m.handlers[p.magic]
+		if !ok {
+			continue
+		}
+		b, ok := m.buffers[p.nonce]
+		if !ok {
+			b = &buffer{}
+			m.buffers[p.nonce] = b
+			for nonce := range m.buffers {
+				if nonce+keptMessages < p.nonce {
+					delete(m.buffers, nonce)
+				}
+			}
+		}
+		if b.decoded || len(p.shard) < 1 || b.has(p.shard[0]) {
+			continue
+		}
+		b.shards = append(b.shards, p.shard)
+		if len(b.shards) < RequiredShards {
+			continue
+		}
+		data, err := fec.Decode(b.shards)
+		if err != nil {
+			log.L.Trace(m.creator, "could not decode message yet", err)
+			continue
+		}
+		b.decoded = true
+		if err = handler(m.ctx, p.src, address, data); err != nil {
+			log.L.Error(err)
+		}
+	}
+}
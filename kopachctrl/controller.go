@@ -4,15 +4,16 @@ import (
 	"container/ring"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/VividCortex/ewma"
 	"go.uber.org/atomic"
 
 	log "github.com/p9c/logi"
+	"github.com/p9c/simplebuffer"
 	"github.com/p9c/simplebuffer/Uint16"
 
 	"github.com/p9c/transport"
@@ -27,12 +28,16 @@ import (
 	blockchain "github.com/p9c/chain"
 	"github.com/p9c/chain/mining"
 
+	"github.com/p9c/kopach/kopachctrl/broadcast"
 	"github.com/p9c/kopach/kopachctrl/hashrate"
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/kopachctrl/history"
 	"github.com/p9c/kopach/kopachctrl/job"
+	"github.com/p9c/kopach/kopachctrl/message"
 	"github.com/p9c/kopach/kopachctrl/p2padvt"
 	"github.com/p9c/kopach/kopachctrl/pause"
+	"github.com/p9c/kopach/kopachctrl/settings"
 	"github.com/p9c/kopach/kopachctrl/sol"
-	"github.com/p9c/pod/pkg/conte"
 )
 
 const (
@@ -42,122 +47,181 @@ const (
 )
 
 type Controller struct {
-	multiConn              *transport.Channel
-	uniConn                *transport.Channel
-	active                 atomic.Bool
-	quit                   chan struct{}
-	cx                     *conte.Xt
-	Ready                  atomic.Bool
-	height                 atomic.Uint64
-	blockTemplateGenerator *mining.BlkTmplGenerator
-	coinbases              map[int32]*util.Tx
-	transactions           []*util.Tx
-	oldBlocks              atomic.Value
-	prevHash               atomic.Value
-	lastTxUpdate           atomic.Value
-	lastGenerated          atomic.Value
-	pauseShards            [][]byte
-	sendAddresses          []*net.UDPAddr
-	submitChan             chan []byte
-	buffer                 *ring.Ring
-	began                  time.Time
-	otherNodes             map[string]time.Time
-	listenPort             int
-	hashCount              atomic.Uint64
-	hashSampleBuf          *rav.BufferUint64
-	lastNonce              int32
+	multiConn        broadcast.Channel
+	active           atomic.Bool
+	quit             chan struct{}
+	quitOnce         sync.Once
+	cfg              Config
+	b                Backend
+	ips              []*net.IP
+	Ready            atomic.Bool
+	height           atomic.Uint64
+	coinbases        map[int32]*util.Tx
+	transactions     []*util.Tx
+	oldBlocks        atomic.Value
+	prevHash         atomic.Value
+	lastTxUpdate     atomic.Value
+	lastGenerated    atomic.Value
+	pauseShards      [][]byte
+	sendAddresses    []*net.UDPAddr
+	submitChan       chan []byte
+	buffer           *ring.Ring
+	began            time.Time
+	nodesMx          sync.Mutex
+	otherNodes       map[string]time.Time
+	listenPort       int
+	hashCount        atomic.Uint64
+	hashrate         atomic.Float64
+	solutions        atomic.Uint64
+	accepted         atomic.Uint64
+	hashSampleBuf    *rav.BufferUint64
+	lastNonce        int32
+	versionMx        sync.Mutex
+	versionHashCount map[int32]uint64
+	registry         *Registry
+	controlKey       string
+	// subs receive the events of the controller
+	subMx   sync.Mutex
+	subs    map[int]chan Event
+	nextSub int
+	metrics *controllerMetrics
+	// history records the blocks found if a file is configured for it
+	history *history.Store
 }
 
-func Run(cx *conte.Xt) (quit chan struct{}) {
-	if len(cx.StateCfg.ActiveMiningAddrs) < 1 {
-		log.L.Warn("no mining addresses, not starting controller")
-		return
+// New creates a controller making jobs for the given node
+func New(cfg Config, b Backend) (c *Controller) {
+	c = &Controller{
+		quit:             make(chan struct{}),
+		cfg:              cfg,
+		b:                b,
+		sendAddresses:    []*net.UDPAddr{},
+		submitChan:       make(chan []byte),
+		coinbases:        make(map[int32]*util.Tx),
+		buffer:           ring.New(BufferSize),
+		began:            time.Now(),
+		otherNodes:       make(map[string]time.Time),
+		listenPort:       int(Uint16.GetActualPort(cfg.Controller)),
+		hashSampleBuf:    rav.NewBufferUint64(1000),
+		versionHashCount: make(map[int32]uint64),
+		registry:         NewRegistry(),
+		controlKey:       cfg.ControlKey,
+		subs:             make(map[int]chan Event),
 	}
-	if len(*cx.Config.RPCListeners) < 1 || *cx.Config.DisableRPC {
-		log.L.Warn("not running controller without RPC enabled")
-		return
+	c.metrics = newControllerMetrics(c)
+	if c.ips = cfg.IPs; len(c.ips) < 1 {
+		if c.ips = p2padvt.Listenable(); c.ips[0].IsLoopback() {
+			log.L.Warn("no routable address, only miners on this host can" +
+				" reach the controller")
+		}
 	}
-	if len(*cx.Config.Listeners) < 1 || *cx.Config.DisableListen {
-		log.L.Warn("not running controller without p2p listener enabled")
-		return
+	return
+}
+
+// Stop shuts the controller down
+func (c *Controller) Stop() {
+	c.quitOnce.Do(func() { close(c.quit) })
+}
+
+// Run sends jobs to the miners until the controller is stopped
+func (c *Controller) Run() (err error) {
+	c.lastTxUpdate.Store(time.Now().UnixNano())
+	c.lastGenerated.Store(time.Now().UnixNano())
+	c.height.Store(0)
+	c.active.Store(false)
+	// the history is ready before solutions can arrive
+	if c.cfg.BlocksFile != "" {
+		if c.history, err = history.Open(c.cfg.BlocksFile); err != nil {
+			log.L.Error("could not open block history", err)
+			err = nil
+		} else {
+			defer func() {
+				if err := c.history.Close(); err != nil {
+					log.L.Error(err)
+				}
+			}()
+		}
 	}
-	ctrl := &Controller{
-		quit:                   make(chan struct{}),
-		cx:                     cx,
-		sendAddresses:          []*net.UDPAddr{},
-		submitChan:             make(chan []byte),
-		blockTemplateGenerator: getBlkTemplateGenerator(cx),
-		coinbases:              make(map[int32]*util.Tx),
-		buffer:                 ring.New(BufferSize),
-		began:                  time.Now(),
-		otherNodes:             make(map[string]time.Time),
-		listenPort:             int(Uint16.GetActualPort(*cx.Config.Controller)),
-		hashSampleBuf:          rav.NewBufferUint64(1000),
+	open := c.cfg.Transport
+	if open == nil {
+		open = broadcast.Multicast(transport.DefaultPort, MaxDatagramSize)
 	}
-	quit = ctrl.quit
-	ctrl.lastTxUpdate.Store(time.Now().UnixNano())
-	ctrl.lastGenerated.Store(time.Now().UnixNano())
-	ctrl.height.Store(0)
-	ctrl.active.Store(false)
-	var err error
-	ctrl.multiConn, err = transport.NewBroadcastChannel("controller",
-		ctrl, *cx.Config.MinerPass,
-		transport.DefaultPort, MaxDatagramSize, handlersMulticast,
-		ctrl.quit)
+	c.multiConn, err = open("controller", c, c.cfg.Pass, handlersMulticast,
+		c.quit)
 	if err != nil {
 		log.L.Error(err)
-		close(ctrl.quit)
+		c.Stop()
 		return
 	}
-	pM := pause.GetPauseContainer(cx)
+	defer c.closeSubscribers()
+	if c.cfg.StatusListener != "" {
+		if err := c.statusServer(); err != nil {
+			log.L.Error("could not serve controller status", err)
+		}
+	}
+	pM := pause.GetPauseContainer(c.ips, c.cfg.P2PListener,
+		c.cfg.RPCListener, c.cfg.Controller)
 	var pauseShards [][]byte
 	if pauseShards = transport.GetShards(pM.Data); log.L.Check(err) {
 	} else {
-		ctrl.active.Store(true)
+		c.active.Store(true)
 	}
-	ctrl.oldBlocks.Store(pauseShards)
+	c.pauseShards = pauseShards
+	c.oldBlocks.Store(pauseShards)
 	interrupt.AddHandler(func() {
 		log.L.Debug("miner controller shutting down")
-		ctrl.active.Store(false)
-		err := ctrl.multiConn.SendMany(pause.PauseMagic, pauseShards)
+		c.active.Store(false)
+		err := c.multiConn.SendMany(pause.PauseMagic, pauseShards)
 		if err != nil {
 			log.L.Error(err)
+		} else {
+			c.metrics.pauses.Inc()
 		}
-		if err = ctrl.multiConn.Close(); log.L.Check(err) {
+		if err = c.multiConn.Close(); err != nil {
+			log.L.Error(err)
 		}
 	})
 	log.L.Debug("sending broadcasts to:", UDP4MulticastAddress)
-	err = ctrl.sendNewBlockTemplate()
+	err = c.sendNewBlockTemplate()
 	if err != nil {
 		log.L.Error(err)
 	} else {
-		ctrl.active.Store(true)
+		c.active.Store(true)
 	}
-	cx.RealNode.Chain.Subscribe(ctrl.getNotifier())
-	go rebroadcaster(ctrl)
-	go submitter(ctrl)
-	go advertiser(ctrl)
+	c.b.Chain.Subscribe(c.getNotifier())
+	go rebroadcaster(c)
+	go submitter(c)
+	go advertiser(c)
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	cont := true
 	for cont {
 		select {
 		case <-ticker.C:
-			if !ctrl.Ready.Load() {
-				if cx.IsCurrent() {
+			c.registry.Sweep()
+			c.hashrate.Store(c.HashReport())
+			if !c.Ready.Load() {
+				if c.b.Chain.IsCurrent() {
 					log.L.Warn("READY!")
-					ctrl.Ready.Store(true)
-					ctrl.active.Store(true)
+					c.Ready.Store(true)
+					c.active.Store(true)
 				}
 			}
-		case <-ctrl.quit:
+		case <-c.quit:
 			cont = false
-			ctrl.active.Store(false)
+			c.active.Store(false)
 		case <-interrupt.HandlersDone:
 			cont = false
 		}
 	}
 	log.L.Trace("controller exiting")
-	return
+	return nil
+}
+
+// advertisment returns the advertisment of the node to the LAN
+func (c *Controller) advertisment() simplebuffer.Serializers {
+	return p2padvt.Get(c.ips, c.cfg.P2PListener, c.cfg.RPCListener,
+		c.cfg.Controller)
 }
 
 func (c *Controller) HashReport() float64 {
@@ -180,21 +244,75 @@ func (c *Controller) HashReport() float64 {
 	return av.Value()
 }
 
-var handlersMulticast = transport.Handlers{
+// HashCounts returns the total number of hashes reported for each block
+// version since the controller started
+func (c *Controller) HashCounts() (out map[int32]uint64) {
+	c.versionMx.Lock()
+	defer c.versionMx.Unlock()
+	out = make(map[int32]uint64, len(c.versionHashCount))
+	for v, n := range c.versionHashCount {
+		out[v] = n
+	}
+	return
+}
+
+// PushSettings sends settings to the miners with the given machine IDs, or
+// to all of them if none are given. The miners answer with a heartbeat
+// showing the settings they are now running with.
+func (c *Controller) PushSettings(s settings.Settings,
+	targets ...string) (err error) {
+	if c.controlKey == "" {
+		return errors.New("no control key is configured so miners will" +
+			" not accept settings")
+	}
+	if err = s.Validate(); err != nil {
+		return
+	}
+	m := settings.Get(c.controlKey, s, targets...)
+	return c.multiConn.SendMany(settings.Magic, transport.GetShards(m.Data))
+}
+
+var handlersMulticast = message.Handlers{
 	// Solutions submitted by workers
-	string(sol.SolutionMagic): func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+	Solution: func(ctx interface{}, msg message.Meta,
+		j *sol.SolContainer) (err error) {
 		log.L.Trace("received solution")
 		c := ctx.(*Controller)
 		if !c.active.Load() { // || !c.cx.Node.Load() {
 			log.L.Debug("not active yet")
 			return
 		}
-		j := sol.LoadSolContainer(b)
 		senderPort := j.GetSenderPort()
 		if int(senderPort) != c.listenPort {
 			return
 		}
+		id := j.GetIdentity()
+		c.registry.Update(id, addrIPs(id, msg.Src), func(m *Miner) {
+			m.Solutions++
+		})
+		c.solutions.Inc()
+		// a new template is made while the block is processed
+		templateAge := time.Since(time.Unix(0, c.lastGenerated.Load().(int64)))
 		msgBlock := j.GetMsgBlock()
+		height := c.b.Chain.BestSnapshot().Height + 1
+		e := Event{
+			Type:     EventSolution,
+			Height:   height,
+			PrevHash: msgBlock.Header.PrevBlock.String(),
+			Algo:     fork.GetAlgoName(msgBlock.Header.Version, height),
+			Outcome:  Unusable,
+			Miner:    id.Name,
+			MinerID:  id.ID,
+		}
+		// the block is announced after the solution it came from
+		var found *Event
+		defer func() {
+			c.metrics.solutions.Inc(e.Outcome)
+			c.publish(e)
+			if found != nil {
+				c.publish(*found)
+			}
+		}()
 		// log.L.Warn(msgBlock.Header.Version)
 		cb, ok := c.coinbases[msgBlock.Header.Version]
 		if !ok {
@@ -207,9 +325,11 @@ var handlersMulticast = transport.Handlers{
 		for i := range txs {
 			msgBlock.Transactions = append(msgBlock.Transactions, txs[i].MsgTx())
 		}
-		if !msgBlock.Header.PrevBlock.IsEqual(&c.cx.RPCServer.Cfg.Chain.
-			BestSnapshot().Hash) {
+		e.Hash = msgBlock.BlockHashWithAlgos(height).String()
+		if !msgBlock.Header.PrevBlock.IsEqual(&c.b.Chain.BestSnapshot().
+			Hash) {
 			log.L.Debug("block submitted by kopach miner worker is stale")
+			e.Outcome = Stale
 			return
 		}
 		// set old blocks to pause and send pause directly as block is
@@ -219,9 +339,9 @@ var handlersMulticast = transport.Handlers{
 			log.L.Error(err)
 			return
 		}
+		c.metrics.pauses.Inc()
 		block := util.NewBlock(msgBlock)
-		isOrphan, err := c.cx.RealNode.SyncManager.ProcessBlock(block,
-			blockchain.BFNone)
+		isOrphan, err := c.b.Blocks.ProcessBlock(block)
 		if err != nil {
 			// Anything other than a rule violation is an unexpected error, so log
 			// that error as an internal error.
@@ -232,17 +352,37 @@ var handlersMulticast = transport.Handlers{
 				return
 			} else {
 				log.L.Warn("block submitted via kopach miner rejected:", err)
+				e.Outcome = Rejected
 				if isOrphan {
 					log.L.Warn("block is an orphan")
+					e.Outcome = Orphan
 					return
 				}
 				return
 			}
 		}
+		if isOrphan {
+			log.L.Warn("block submitted via kopach miner is an orphan")
+			e.Outcome = Orphan
+			return
+		}
 		log.L.Trace("the block was accepted")
-		coinbaseTx := block.MsgBlock().Transactions[0].TxOut[0]
+		e.Outcome = Accepted
+		c.accepted.Inc()
+		c.registry.Update(id, addrIPs(id, msg.Src), func(m *Miner) {
+			m.Accepted++
+		})
+		// the coinbase may pay the subsidy out over several outputs
+		var coinbase int64
+		for _, out := range block.MsgBlock().Transactions[0].TxOut {
+			coinbase += out.Value
+		}
 		prevHeight := block.Height() - 1
-		prevBlock, _ := c.cx.RealNode.Chain.BlockByHeight(prevHeight)
+		prevBlock, err := c.b.Blocks.BlockByHeight(prevHeight)
+		if err != nil {
+			log.L.Error(err)
+			return nil
+		}
 		prevTime := prevBlock.MsgBlock().Header.Timestamp.Unix()
 		since := block.MsgBlock().Header.Timestamp.Unix() - prevTime
 		bHash := block.MsgBlock().BlockHashWithAlgos(block.Height())
@@ -252,21 +392,50 @@ var handlersMulticast = transport.Handlers{
 			bHash,
 			block.MsgBlock().Header.Timestamp.Unix(),
 			block.MsgBlock().Header.Bits,
-			util.Amount(coinbaseTx.Value),
+			util.Amount(coinbase),
 			fork.GetAlgoName(block.MsgBlock().Header.Version, block.Height()), since)
+		if id.ID != "" {
+			log.L.Warn("block found by", id.Name, id.ID)
+		}
+		if c.history != nil {
+			hb := &history.Block{
+				Time:        time.Now(),
+				Height:      block.Height(),
+				Hash:        bHash.String(),
+				Algo:        e.Algo,
+				Version:     block.MsgBlock().Header.Version,
+				Bits:        block.MsgBlock().Header.Bits,
+				Coinbase:    coinbase,
+				SincePrev:   time.Duration(since) * time.Second,
+				MinerID:     id.ID,
+				Miner:       id.Name,
+				TemplateAge: templateAge,
+			}
+			if id.ID == "" {
+				hb.MinerID = minerKey(id, addrIPs(id, msg.Src))
+				hb.Miner = hb.MinerID
+			}
+			if err := c.history.Add(hb); err != nil {
+				log.L.Error("could not record block", err)
+			}
+		}
+		b := e
+		b.Type, b.Height, b.Hash = EventBlock, block.Height(), bHash.String()
+		found = &b
 		return
 	},
-	string(p2padvt.Magic): func(ctx interface{}, src net.Addr, dst string,
-		b []byte) (err error) {
+	Advertisment: func(ctx interface{}, msg message.Meta,
+		j p2padvt.Container) (err error) {
 		c := ctx.(*Controller)
 		if !c.active.Load() {
 			log.L.Debug("not active")
 			return
 		}
-		j := p2padvt.LoadContainer(b)
 		otherIPs := j.GetIPs()
 		otherPort := fmt.Sprint(j.GetP2PListenersPort())
-		myPort := strings.Split((*c.cx.Config.Listeners)[0], ":")[1]
+		myPort := strings.Split(c.cfg.P2PListener, ":")[1]
+		c.nodesMx.Lock()
+		defer c.nodesMx.Unlock()
 		for i := range otherIPs {
 			o := fmt.Sprintf("%s:%s", otherIPs[i], otherPort)
 			if otherPort != myPort {
@@ -275,7 +444,8 @@ var handlersMulticast = transport.Handlers{
 					// recommended).
 					// go func() {
 					log.L.Warn("connecting to lan peer with same PSK", o)
-					if err = c.cx.RPCServer.Cfg.ConnMgr.Connect(o, true); log.L.Check(err) {
+					if err = c.b.Peers.Connect(o); err != nil {
+						log.L.Error(err)
 					}
 				}
 				c.otherNodes[o] = time.Now()
@@ -286,40 +456,70 @@ var handlersMulticast = transport.Handlers{
 				delete(c.otherNodes, i)
 			}
 		}
-		c.cx.OtherNodes.Store(int32(len(c.otherNodes)))
+		c.b.Peers.SetOtherNodes(int32(len(c.otherNodes)))
+		return
+	},
+	// heartbeats from kopach machines
+	Heartbeat: func(ctx interface{}, msg message.Meta,
+		hb heartbeat.Container) (err error) {
+		c := ctx.(*Controller)
+		h := hb.Struct()
+		c.registry.Heartbeat(&h)
 		return
 	},
 	// hashrate reports from workers
-	string(hashrate.HashrateMagic): func(ctx interface{}, src net.Addr, dst string, b []byte) (err error) {
+	Hashrate: func(ctx interface{}, msg message.Meta,
+		hp hashrate.Container) (err error) {
 		c := ctx.(*Controller)
 		if !c.active.Load() {
 			log.L.Debug("not active")
 			return
 		}
-		hp := hashrate.LoadContainer(b)
-		count := hp.GetCount()
 		nonce := hp.GetNonce()
 		if c.lastNonce == nonce {
 			return
 		}
 		c.lastNonce = nonce
+		// both single worker and per machine reports carry the total in the
+		// count, the per machine ones also break it down by version
+		count := hp.GetCount()
+		counts := hp.GetCounts()
+		c.versionMx.Lock()
+		for v, n := range counts {
+			c.versionHashCount[v] += uint64(n)
+		}
+		c.versionMx.Unlock()
+		id := hp.GetIdentity()
+		c.registry.Update(id, addrIPs(id, msg.Src), func(m *Miner) {
+			for v, n := range counts {
+				m.HashCounts[v] += uint64(n)
+			}
+			m.Hashes += uint64(count)
+		})
 		// add to total hash counts
 		c.hashCount.Store(c.hashCount.Load() + uint64(count))
 		return
 	},
-}
+	Rejected: rejected,
+}.Transport()
 
 func (c *Controller) sendNewBlockTemplate() (err error) {
-	template := getNewBlockTemplate(c.cx, c.blockTemplateGenerator)
+	start := time.Now()
+	template := c.getNewBlockTemplate()
 	if template == nil {
 		err = errors.New("could not get template")
 		log.L.Error(err)
+		c.metrics.templateBuilt(start, err)
 		return
 	}
 	msgB := template.Block
 	c.coinbases = make(map[int32]*util.Tx)
 	var fMC job.Container
-	fMC, c.transactions = job.Get(c.cx, util.NewBlock(msgB), p2padvt.Get(c.cx), &c.coinbases)
+	fMC, c.transactions, err = c.getJob(msgB)
+	c.metrics.templateBuilt(start, err)
+	if err != nil {
+		return
+	}
 	shards := transport.GetShards(fMC.Data)
 	shardsLen := len(shards)
 	if shardsLen < 1 {
@@ -329,55 +529,52 @@ func (c *Controller) sendNewBlockTemplate() (err error) {
 	err = c.multiConn.SendMany(job.Magic, shards)
 	if err != nil {
 		log.L.Error(err)
+	} else {
+		c.metrics.jobs.Inc(NewJob)
 	}
+	c.height.Store(uint64(fMC.GetNewHeight()))
 	c.prevHash.Store(&template.Block.Header.PrevBlock)
+	c.publishJob(fMC.GetNewHeight(), &template.Block.Header.PrevBlock)
 	c.oldBlocks.Store(shards)
 	c.lastGenerated.Store(time.Now().UnixNano())
 	c.lastTxUpdate.Store(time.Now().UnixNano())
 	return
 }
 
-func getNewBlockTemplate(cx *conte.Xt, bTG *mining.BlkTmplGenerator,
-) (template *mining.BlockTemplate) {
-	log.L.Trace("getting new block template")
-	if len(*cx.Config.MiningAddrs) < 1 {
-		log.L.Debug("no mining addresses")
-		return
-	}
-	// Choose a payment address at random.
-	rand.Seed(time.Now().UnixNano())
-	payToAddr := cx.StateCfg.ActiveMiningAddrs[rand.Intn(len(*cx.Config.
-		MiningAddrs))]
-	log.L.Trace("calling new block template")
-	template, err := bTG.NewBlockTemplate(0, payToAddr,
-		fork.SHA256d)
+// publishJob tells the subscribers about a new job
+func (c *Controller) publishJob(height int32, prev *chainhash.Hash) {
+	c.publish(Event{Type: EventJob, Height: height,
+		PrevHash: prev.String()})
+}
+
+func (c *Controller) getNewBlockTemplate() (template *mining.BlockTemplate) {
+	template, err := c.b.Templates.NewBlockTemplate()
 	if err != nil {
 		log.L.Error(err)
-	} else {
-		// log.L.Debug("got new block template")
 	}
 	return
 }
 
-func getBlkTemplateGenerator(cx *conte.Xt) *mining.BlkTmplGenerator {
-	policy := mining.Policy{
-		BlockMinWeight:    uint32(*cx.Config.BlockMinWeight),
-		BlockMaxWeight:    uint32(*cx.Config.BlockMaxWeight),
-		BlockMinSize:      uint32(*cx.Config.BlockMinSize),
-		BlockMaxSize:      uint32(*cx.Config.BlockMaxSize),
-		BlockPrioritySize: uint32(*cx.Config.BlockPrioritySize),
-		TxMinFreeFee:      cx.StateCfg.ActiveMinRelayTxFee,
+// getJob makes the job for a block template, which is for the block after
+// the tip of the best chain
+func (c *Controller) getJob(msgB *wire.MsgBlock) (mC job.Container,
+	txr []*util.Tx, err error) {
+	var bitsMap blockchain.TargetBits
+	if bitsMap, err = c.b.Difficulty.NextTargets(); err != nil {
+		log.L.Error(err)
+		return
 	}
-	s := cx.RealNode
-	return mining.NewBlkTmplGenerator(&policy,
-		s.ChainParams, s.TxMemPool, s.Chain, s.TimeSource,
-		s.SigCache, s.HashCache, s.Algo)
+	height := c.b.Chain.BestSnapshot().Height + 1
+	mC, txr = job.Get(c.cfg.Params, height, bitsMap, util.NewBlock(msgB),
+		c.advertisment(), &c.coinbases)
+	return
 }
 
 func advertiser(ctrl *Controller) {
 	advertismentTicker := time.NewTicker(time.Second)
-	advt := p2padvt.Get(ctrl.cx)
-	ad := transport.GetShards(advt.CreateContainer(p2padvt.Magic).Data)
+	advt := p2padvt.GetContainer(ctrl.ips, ctrl.cfg.P2PListener,
+		ctrl.cfg.RPCListener, ctrl.cfg.Controller)
+	ad := transport.GetShards(advt.Data)
 out:
 	for {
 		select {
@@ -398,11 +595,11 @@ out:
 	for {
 		select {
 		case <-rebroadcastTicker.C:
-			if !c.cx.IsCurrent() {
+			if !c.b.Chain.IsCurrent() {
 				break
 			}
 			// The current block is stale if the best block has changed.
-			best := c.blockTemplateGenerator.BestSnapshot()
+			best := c.b.Chain.BestSnapshot()
 			if !c.prevHash.Load().(*chainhash.Hash).IsEqual(&best.Hash) {
 				log.L.Debug("new best block hash")
 				c.UpdateAndSendTemplate()
@@ -411,9 +608,9 @@ out:
 			// The current block is stale if the memory pool has been updated
 			// since the block template was generated and it has been at least
 			// one minute.
-			if c.lastTxUpdate.Load() != c.blockTemplateGenerator.GetTxSource().
-				LastUpdated() && time.Now().After(time.Unix(0,
-				c.lastGenerated.Load().(int64)+int64(time.Minute))) {
+			if c.lastTxUpdate.Load() != c.b.Templates.TxLastUpdated() &&
+				time.Now().After(time.Unix(0,
+					c.lastGenerated.Load().(int64)+int64(time.Minute))) {
 				log.L.Debug("block is stale")
 				c.UpdateAndSendTemplate()
 				break
@@ -428,6 +625,8 @@ out:
 			err := c.multiConn.SendMany(job.Magic, oB)
 			if err != nil {
 				log.L.Error(err)
+			} else {
+				c.metrics.jobs.Inc(Rebroadcast)
 			}
 			c.oldBlocks.Store(oB)
 			break
@@ -482,7 +681,8 @@ func (c *Controller) getNotifier() func(n *blockchain.Notification) {
 
 func (c *Controller) UpdateAndSendTemplate() {
 	c.coinbases = make(map[int32]*util.Tx)
-	template := getNewBlockTemplate(c.cx, c.blockTemplateGenerator)
+	start := time.Now()
+	template := c.getNewBlockTemplate()
 	if template != nil {
 		c.transactions = []*util.Tx{}
 		for _, v := range template.Block.Transactions[1:] {
@@ -490,8 +690,12 @@ func (c *Controller) UpdateAndSendTemplate() {
 		}
 		msgB := template.Block
 		var mC job.Container
-		mC, c.transactions = job.Get(c.cx, util.NewBlock(msgB),
-			p2padvt.Get(c.cx), &c.coinbases)
+		var err error
+		mC, c.transactions, err = c.getJob(msgB)
+		c.metrics.templateBuilt(start, err)
+		if err != nil {
+			return
+		}
 		nH := mC.GetNewHeight()
 		if c.height.Load() < uint64(nH) {
 			log.L.Trace("new height", nH)
@@ -499,12 +703,17 @@ func (c *Controller) UpdateAndSendTemplate() {
 		}
 		shards := transport.GetShards(mC.Data)
 		c.oldBlocks.Store(shards)
-		if err := c.multiConn.SendMany(job.Magic, shards); log.L.Check(err) {
+		if err := c.multiConn.SendMany(job.Magic, shards); err != nil {
+			log.L.Error(err)
+		} else {
+			c.metrics.jobs.Inc(NewJob)
 		}
 		c.prevHash.Store(&template.Block.Header.PrevBlock)
+		c.publishJob(nH, &template.Block.Header.PrevBlock)
 		c.lastGenerated.Store(time.Now().UnixNano())
 		c.lastTxUpdate.Store(time.Now().UnixNano())
 	} else {
 		log.L.Debug("got nil template")
+		c.metrics.templateBuilt(start, errors.New("could not get template"))
 	}
 }
@@ -0,0 +1,63 @@
+package hashrate
+
+import (
+	"encoding/binary"
+)
+
+// Counts is a serializer for a count of hashes per block version
+type Counts struct {
+	Length byte
+	Counts map[int32]int32
+}
+
+func NewCounts() *Counts {
+	return &Counts{Counts: make(map[int32]int32)}
+}
+
+func (c *Counts) DecodeOne(b []byte) *Counts {
+	c.Decode(b)
+	return c
+}
+
+func (c *Counts) Decode(b []byte) (out []byte) {
+	if len(b) < 1 {
+		return
+	}
+	c.Length = b[0]
+	end := 1 + int(c.Length)*8
+	if len(b) < end {
+		return
+	}
+	for i := 1; i < end; i += 8 {
+		c.Counts[int32(binary.BigEndian.Uint32(b[i:i+4]))] =
+			int32(binary.BigEndian.Uint32(b[i+4 : i+8]))
+	}
+	if len(b) > end {
+		out = b[end:]
+	}
+	return
+}
+
+func (c *Counts) Encode() (out []byte) {
+	out = []byte{c.Length}
+	for v, n := range c.Counts {
+		by := make([]byte, 8)
+		binary.BigEndian.PutUint32(by[:4], uint32(v))
+		binary.BigEndian.PutUint32(by[4:], uint32(n))
+		out = append(out, by...)
+	}
+	return
+}
+
+func (c *Counts) Get() map[int32]int32 {
+	return c.Counts
+}
+
+func (c *Counts) Put(in map[int32]int32) *Counts {
+	c.Length = byte(len(in))
+	c.Counts = make(map[int32]int32, len(in))
+	for v, n := range in {
+		c.Counts[v] = n
+	}
+	return c
+}
@@ -2,7 +2,14 @@
 // broadcast an IP address, a count and version number and current height
 // of mining work just completed. This data should be stored in a log file and
 // added together to generate hashrate reporting in nodes when their controller
-// is running
+// is running.
+//
+// Reports made by Get describe a single run of one algorithm by one worker.
+// Reports made by GetAggregate add the per version counts of all the workers
+// of a machine over an interval and the identity of the machine as extra
+// fields at the end, and fill the older fields with the total and the
+// busiest version so controllers that don't know about the extra fields
+// still count the hashes correctly.
 package hashrate
 
 import (
@@ -11,6 +18,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"time"
 
 	log "github.com/p9c/logi"
@@ -19,10 +27,33 @@ import (
 	"github.com/p9c/simplebuffer/IPs"
 	"github.com/p9c/simplebuffer/Int32"
 	"github.com/p9c/simplebuffer/Time"
+
+	"github.com/p9c/kopach/kopachctrl/identity"
+	"github.com/p9c/kopach/kopachctrl/protocol"
 )
 
 var HashrateMagic = []byte{'h', 'a', 's', 'h'}
 
+// Schema describes the fields of a hashrate report. Single worker reports
+// have only the first six fields.
+var Schema = &protocol.Schema{
+	Name:  "hashrate",
+	Magic: HashrateMagic,
+	Fields: []protocol.Field{
+		{Name: "Time", Kind: protocol.Time},
+		{Name: "IPs", Kind: protocol.AnyIPs},
+		{Name: "Count", Kind: protocol.Int32},
+		{Name: "Version", Kind: protocol.Int32},
+		{Name: "Height", Kind: protocol.Int32},
+		{Name: "Nonce", Kind: protocol.Int32},
+		{Name: "Counts", Kind: protocol.Counts},
+		{Name: "Identity", Kind: protocol.Identity},
+		{Name: "Protocol", Kind: protocol.Protocol, Since: 1},
+	},
+	Required:  6,
+	VersionAt: 8,
+}
+
 type Container struct {
 	simplebuffer.Container
 }
@@ -34,20 +65,48 @@ type Hashrate struct {
 	Version int32
 	Height  int32
 	Nonce   int32
+	// Counts is the number of hashes done on each version. For single
+	// version reports this is just Count at Version.
+	Counts map[int32]int
+	// Identity is the machine that made the report, it is empty for single
+	// worker reports
+	Identity identity.Identity
 }
 
 func Get(count int32, version int32, height int32) Container {
+	return Container{*getSerializers(count, version, height).
+		CreateContainer(HashrateMagic)}
+}
+
+// GetAggregate returns a report covering the hashes done on each version by
+// all of the workers of a machine
+func GetAggregate(counts map[int32]int32, height int32,
+	id identity.Identity) Container {
+	var total, top, version int32
+	for v, c := range counts {
+		total += c
+		if c > top || (c == top && v < version) {
+			top, version = c, v
+		}
+	}
+	srs := append(getSerializers(total, version, height),
+		NewCounts().Put(counts), identity.New().Put(id), protocol.NewStamp())
+	return Container{*srs.CreateContainer(HashrateMagic)}
+}
+
+func getSerializers(count int32, version int32,
+	height int32) simplebuffer.Serializers {
 	nonce := make([]byte, 4)
 	if _, err := io.ReadFull(rand.Reader, nonce); log.L.Check(err) {
 	}
-	return Container{*simplebuffer.Serializers{
+	return simplebuffer.Serializers{
 		Time.New().Put(time.Now()),
 		IPs.GetListenable(),
 		Int32.New().Put(count),
 		Int32.New().Put(version),
 		Int32.New().Put(height),
 		Int32.New().Put(int32(binary.BigEndian.Uint32(nonce))),
-	}.CreateContainer(HashrateMagic)}
+	}
 }
 
 // LoadContainer takes a message byte slice payload and loads it into a container
@@ -57,6 +116,16 @@ func LoadContainer(b []byte) (out Container) {
 	return
 }
 
+// Load checks a message has the fields of a hashrate report and that each
+// can be decoded, and loads it into a container
+func Load(b []byte) (out Container, err error) {
+	if _, err = Schema.Check(b); err != nil {
+		return
+	}
+	out.Data = b
+	return
+}
+
 func (j *Container) GetTime() time.Time {
 	return Time.New().DecodeOne(j.Get(0)).Get()
 }
@@ -81,6 +150,34 @@ func (j *Container) GetNonce() int32 {
 	return Int32.New().DecodeOne(j.Get(5)).Get()
 }
 
+// IsAggregate returns true if the report carries per version counts
+func (j *Container) IsAggregate() bool {
+	return j.Count() > 6
+}
+
+// GetIdentity returns the machine that sent an aggregate report, or an empty
+// identity for single worker reports
+func (j *Container) GetIdentity() (out identity.Identity) {
+	if j.Count() > 7 {
+		out = identity.New().DecodeOne(j.Get(7)).Get()
+	}
+	return
+}
+
+// GetCounts returns the hashes done per version. Single version reports
+// return their count under their version.
+func (j *Container) GetCounts() (out map[int32]int) {
+	out = make(map[int32]int)
+	if !j.IsAggregate() {
+		out[j.GetVersion()] = j.GetCount()
+		return
+	}
+	for v, c := range NewCounts().DecodeOne(j.Get(6)).Get() {
+		out[v] = int(c)
+	}
+	return
+}
+
 func (j *Container) String() (s string) {
 	s += fmt.Sprint("\ntype '"+string(HashrateMagic)+"' elements:", j.Count())
 	s += "\n"
@@ -102,6 +199,22 @@ func (j *Container) String() (s string) {
 	s += "4 Version: "
 	s += fmt.Sprint(version)
 	s += "\n"
+	if j.IsAggregate() {
+		counts := j.GetCounts()
+		var versions []int
+		for i := range counts {
+			versions = append(versions, int(i))
+		}
+		sort.Ints(versions)
+		s += "7 Counts:\n"
+		for i := range versions {
+			s += fmt.Sprintf("  %2d %d\n", versions[i],
+				counts[int32(versions[i])])
+		}
+		if j.Count() > 7 {
+			s += "8 Identity: " + j.GetIdentity().String() + "\n"
+		}
+	}
 	return
 }
 
@@ -112,12 +225,14 @@ func (j *Container) String() (s string) {
 // block height, which can change between hard fork versions
 func (j *Container) Struct() (out Hashrate) {
 	out = Hashrate{
-		Time:    j.GetTime(),
-		IPs:     j.GetIPs(),
-		Count:   j.GetCount(),
-		Version: j.GetVersion(),
-		Height:  j.GetHeight(),
-		Nonce:   j.GetNonce(),
+		Time:     j.GetTime(),
+		IPs:      j.GetIPs(),
+		Count:    j.GetCount(),
+		Version:  j.GetVersion(),
+		Height:   j.GetHeight(),
+		Nonce:    j.GetNonce(),
+		Counts:   j.GetCounts(),
+		Identity: j.GetIdentity(),
 	}
 	return
 }
@@ -0,0 +1,16 @@
+//go:build gofuzz
+// +build gofuzz
+
+package heartbeat
+
+// Fuzz is the go-fuzz entry point for heartbeats, any message Load accepts
+// must decode without panicking
+func Fuzz(data []byte) int {
+	h, err := Load(data)
+	if err != nil {
+		return 0
+	}
+	_ = h.Struct()
+	_ = h.String()
+	return 1
+}
@@ -0,0 +1,247 @@
+// Package heartbeat is a message type sent regularly by every kopach machine
+// to announce itself to the controllers on the LAN, whether or not it is
+// currently mining for one of them
+package heartbeat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/p9c/simplebuffer"
+	"github.com/p9c/simplebuffer/IPs"
+	"github.com/p9c/simplebuffer/Int32"
+	"github.com/p9c/simplebuffer/Time"
+
+	"github.com/p9c/kopach/kopachctrl/hashrate"
+	"github.com/p9c/kopach/kopachctrl/identity"
+	"github.com/p9c/kopach/kopachctrl/protocol"
+	"github.com/p9c/kopach/kopachctrl/settings"
+	"github.com/p9c/kopach/simplebuffer/String"
+	"github.com/p9c/kopach/simplebuffer/Strings"
+)
+
+var Magic = []byte{'b', 'e', 'a', 't'}
+
+// Schema describes the fields of a heartbeat. Machines from before the
+// settings were reported send only the first six.
+var Schema = &protocol.Schema{
+	Name:  "heartbeat",
+	Magic: Magic,
+	Fields: []protocol.Field{
+		{Name: "Time", Kind: protocol.Time},
+		{Name: "IPs", Kind: protocol.AnyIPs},
+		{Name: "Identity", Kind: protocol.Identity},
+		{Name: "Workers", Kind: protocol.Int32},
+		{Name: "Controller", Kind: protocol.String},
+		{Name: "Status", Kind: protocol.String},
+		{Name: "Algos", Kind: protocol.Strings},
+		{Name: "DutyCycle", Kind: protocol.Int32},
+		{Name: "PauseWindow", Kind: protocol.String},
+		{Name: "Configured", Kind: protocol.Time},
+		{Name: "Mix", Kind: protocol.Counts},
+		{Name: "Mining", Kind: protocol.Strings},
+		{Name: "Protocol", Kind: protocol.Protocol, Since: 1},
+	},
+	Required:  6,
+	VersionAt: 12,
+}
+
+// The statuses a kopach machine reports
+const (
+	// Waiting means no controller is sending work
+	Waiting = "waiting"
+	// Mining means workers are running on a job
+	Mining = "mining"
+	// Paused means the controller has told the workers to stop
+	Paused = "paused"
+	// Held means the duty cycle or pause window has stopped the workers
+	Held = "held"
+)
+
+type Container struct {
+	simplebuffer.Container
+}
+
+type Heartbeat struct {
+	Time     time.Time
+	IPs      []*net.IP
+	Identity identity.Identity
+	// Workers is the number of workers currently running
+	Workers int32
+	// Controller is the address of the controller the miner is taking work
+	// from, empty if it has none
+	Controller string
+	Status     string
+	// Settings are the settings the machine is running with, and Configured
+	// is the time of the settings message they were last changed by, so
+	// controllers can see their changes have been applied
+	Settings   settings.Settings
+	Configured time.Time
+	// Mix is the thousandths of the hashing time the workers give to each
+	// block version
+	Mix map[int32]int32
+	// Algos is the names of the algorithms the workers may mine, after
+	// the settings are limited by the configuration of the machine
+	Algos []string
+}
+
+func Get(id identity.Identity, workers int32, controller,
+	status string, s settings.Settings, configured time.Time,
+	mix map[int32]int32, algos []string) Container {
+	return Container{*simplebuffer.Serializers{
+		Time.New().Put(time.Now()),
+		IPs.GetListenable(),
+		identity.New().Put(id),
+		Int32.New().Put(workers),
+		String.New().Put(controller),
+		String.New().Put(status),
+		Strings.New().Put(s.Algos),
+		Int32.New().Put(s.DutyCycle),
+		String.New().Put(s.PauseWindow),
+		Time.New().Put(configured),
+		hashrate.NewCounts().Put(mix),
+		Strings.New().Put(algos),
+		protocol.NewStamp(),
+	}.CreateContainer(Magic)}
+}
+
+// LoadContainer takes a message byte slice payload and loads it into a container
+// ready to be decoded
+func LoadContainer(b []byte) (out Container) {
+	out.Data = b
+	return
+}
+
+// Load checks a message has the fields of a heartbeat and that each can be
+// decoded, and loads it into a container
+func Load(b []byte) (out Container, err error) {
+	if _, err = Schema.Check(b); err != nil {
+		return
+	}
+	out.Data = b
+	return
+}
+
+func (j *Container) GetTime() time.Time {
+	return Time.New().DecodeOne(j.Get(0)).Get()
+}
+
+func (j *Container) GetIPs() []*net.IP {
+	return IPs.New().DecodeOne(j.Get(1)).Get()
+}
+
+func (j *Container) GetIdentity() identity.Identity {
+	return identity.New().DecodeOne(j.Get(2)).Get()
+}
+
+func (j *Container) GetWorkers() int32 {
+	return Int32.New().DecodeOne(j.Get(3)).Get()
+}
+
+func (j *Container) GetController() string {
+	return String.New().DecodeOne(j.Get(4)).Get()
+}
+
+func (j *Container) GetStatus() string {
+	return String.New().DecodeOne(j.Get(5)).Get()
+}
+
+// GetSettings returns the settings the machine is running with
+func (j *Container) GetSettings() (out settings.Settings) {
+	if j.Count() > 8 {
+		out = settings.Settings{
+			Workers:     j.GetWorkers(),
+			Algos:       Strings.New().DecodeOne(j.Get(6)).Get(),
+			DutyCycle:   Int32.New().DecodeOne(j.Get(7)).Get(),
+			PauseWindow: String.New().DecodeOne(j.Get(8)).Get(),
+		}
+	}
+	return
+}
+
+// GetConfigured returns the time of the last settings message applied
+func (j *Container) GetConfigured() (out time.Time) {
+	if j.Count() > 9 {
+		out = Time.New().DecodeOne(j.Get(9)).Get()
+	}
+	return
+}
+
+// GetMix returns the share of time the workers give to each version
+func (j *Container) GetMix() (out map[int32]int32) {
+	if j.Count() > 10 {
+		out = hashrate.NewCounts().DecodeOne(j.Get(10)).Get()
+	}
+	return
+}
+
+// GetAlgos returns the algorithms the workers of the machine may mine
+func (j *Container) GetAlgos() (out []string) {
+	if j.Count() > 11 {
+		out = Strings.New().DecodeOne(j.Get(11)).Get()
+	}
+	return
+}
+
+func (j *Container) String() (s string) {
+	s += fmt.Sprint("\ntype '"+string(Magic)+"' elements:", j.Count())
+	s += "\n"
+	s += fmt.Sprint("1 Time: ", j.GetTime())
+	s += "\n"
+	ips := j.GetIPs()
+	s += "2 IPs:"
+	for i := range ips {
+		s += fmt.Sprint(" ", ips[i].String())
+	}
+	s += "\n"
+	s += "3 Identity: " + j.GetIdentity().String()
+	s += "\n"
+	s += fmt.Sprint("4 Workers: ", j.GetWorkers())
+	s += "\n"
+	s += "5 Controller: " + j.GetController()
+	s += "\n"
+	s += "6 Status: " + j.GetStatus()
+	s += "\n"
+	if j.Count() > 8 {
+		st := j.GetSettings()
+		s += "7 Algos: " + strings.Join(st.Algos, " ")
+		s += "\n"
+		s += fmt.Sprint("8 Duty cycle: ", st.DutyCycle)
+		s += "\n"
+		s += "9 Pause window: " + st.PauseWindow
+		s += "\n"
+	}
+	if j.Count() > 9 {
+		s += fmt.Sprint("10 Configured: ", j.GetConfigured())
+		s += "\n"
+	}
+	if j.Count() > 10 {
+		s += fmt.Sprint("11 Mix: ", j.GetMix())
+		s += "\n"
+	}
+	if j.Count() > 11 {
+		s += "12 Mining: " + strings.Join(j.GetAlgos(), " ")
+		s += "\n"
+	}
+	return
+}
+
+// Struct deserializes the data all in one go by calling the field deserializing
+// functions into a structure containing the fields.
+func (j *Container) Struct() (out Heartbeat) {
+	out = Heartbeat{
+		Time:       j.GetTime(),
+		IPs:        j.GetIPs(),
+		Identity:   j.GetIdentity(),
+		Workers:    j.GetWorkers(),
+		Controller: j.GetController(),
+		Status:     j.GetStatus(),
+		Settings:   j.GetSettings(),
+		Configured: j.GetConfigured(),
+		Mix:        j.GetMix(),
+		Algos:      j.GetAlgos(),
+	}
+	return
+}
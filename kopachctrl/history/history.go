@@ -0,0 +1,276 @@
+// Package history keeps a record of the blocks found by the miners of a
+// controller. Each block accepted is appended to a file in the data
+// directory as a line of JSON, so it survives restarts and can be reported on
+// by kopach blocks.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/p9c/util"
+)
+
+// MaxLine is the longest line of the store that can be read
+const MaxLine = 1 << 16
+
+// Path returns the path of the store of a network in a data directory
+func Path(dataDir, network string) string {
+	return filepath.Join(dataDir, "blocks-"+network+".json")
+}
+
+// Block is a block found by a miner
+type Block struct {
+	// Time is when the block was accepted
+	Time    time.Time `json:"time"`
+	Height  int32     `json:"height"`
+	Hash    string    `json:"hash"`
+	Algo    string    `json:"algo"`
+	Version int32     `json:"version"`
+	Bits    uint32    `json:"bits"`
+	// Coinbase is the value paid by the coinbase
+	Coinbase int64 `json:"coinbase"`
+	// SincePrev is the time between the timestamps of the block and the one
+	// before it
+	SincePrev time.Duration `json:"sincePrev"`
+	// MinerID and Miner are the machine that sent the solution, with the
+	// address it came from if it did not identify itself
+	MinerID string `json:"minerID"`
+	Miner   string `json:"miner"`
+	// TemplateAge is how long the template of the job had been out when the
+	// solution arrived
+	TemplateAge time.Duration `json:"templateAge"`
+}
+
+// Store appends blocks to a file
+type Store struct {
+	mx   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// Open opens the store at a path, creating it if it does not exist
+func Open(path string) (s *Store, err error) {
+	var f *os.File
+	if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+		0600); err != nil {
+		return
+	}
+	return &Store{path: path, f: f}, nil
+}
+
+// Add appends a block to the store
+func (s *Store) Add(b *Block) (err error) {
+	var line []byte
+	if line, err = json.Marshal(b); err != nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	_, err = s.f.Write(append(line, '\n'))
+	return
+}
+
+// Blocks reads all the blocks in the store
+func (s *Store) Blocks() (blocks []Block, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return ReadFile(s.path)
+}
+
+// Close closes the file of the store
+func (s *Store) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.f.Close()
+}
+
+// ReadFile reads the blocks of the store at a path
+func ReadFile(path string) (blocks []Block, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer func() {
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+	}()
+	return Read(f)
+}
+
+// Read reads blocks in the format of the store. Empty lines are skipped.
+func Read(r io.Reader) (blocks []Block, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, MaxLine)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var b Block
+		if err = json.Unmarshal(s.Bytes(), &b); err != nil {
+			return nil, fmt.Errorf("block history line %d: %v", line, err)
+		}
+		blocks = append(blocks, b)
+	}
+	err = s.Err()
+	return
+}
+
+// Total is the blocks found by an algorithm or machine
+type Total struct {
+	Name   string `json:"name"`
+	Blocks int    `json:"blocks"`
+	// Coinbase is the total paid by the coinbases of the blocks
+	Coinbase int64 `json:"coinbase"`
+	// Share is the fraction of all the blocks
+	Share float64 `json:"share"`
+	// TemplateAge is the average age of the templates of the blocks
+	TemplateAge time.Duration `json:"templateAge"`
+	Last        time.Time     `json:"last"`
+}
+
+// Report is the totals of a history
+type Report struct {
+	Blocks   int       `json:"blocks"`
+	Coinbase int64     `json:"coinbase"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	// Interval is the average time between the blocks found
+	Interval  time.Duration `json:"interval"`
+	ByAlgo    []Total       `json:"byAlgo"`
+	ByMachine []Total       `json:"byMachine"`
+}
+
+// Summarize totals the blocks by algorithm and by machine, with the most
+// blocks first
+func Summarize(blocks []Block) (r Report) {
+	algos := make(map[string]*Total)
+	machines := make(map[string]*Total)
+	for i := range blocks {
+		b := &blocks[i]
+		r.Blocks++
+		r.Coinbase += b.Coinbase
+		if r.First.IsZero() || b.Time.Before(r.First) {
+			r.First = b.Time
+		}
+		if b.Time.After(r.Last) {
+			r.Last = b.Time
+		}
+		machine := b.Miner
+		if b.MinerID != "" && b.MinerID != b.Miner {
+			machine += " (" + b.MinerID + ")"
+		}
+		add(algos, b.Algo, b)
+		add(machines, machine, b)
+	}
+	if r.Blocks > 1 {
+		r.Interval = r.Last.Sub(r.First) / time.Duration(r.Blocks-1)
+	}
+	r.ByAlgo = totals(algos, r.Blocks)
+	r.ByMachine = totals(machines, r.Blocks)
+	return
+}
+
+func add(m map[string]*Total, name string, b *Block) {
+	t, ok := m[name]
+	if !ok {
+		t = &Total{Name: name}
+		m[name] = t
+	}
+	t.Blocks++
+	t.Coinbase += b.Coinbase
+	// the sum is averaged by totals
+	t.TemplateAge += b.TemplateAge
+	if b.Time.After(t.Last) {
+		t.Last = b.Time
+	}
+}
+
+func totals(m map[string]*Total, blocks int) (out []Total) {
+	out = []Total{}
+	for _, t := range m {
+		t.TemplateAge /= time.Duration(t.Blocks)
+		t.Share = float64(t.Blocks) / float64(blocks)
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Blocks != out[j].Blocks {
+			return out[i].Blocks > out[j].Blocks
+		}
+		return out[i].Name < out[j].Name
+	})
+	return
+}
+
+// Print writes the totals of a report and the latest of its blocks as
+// tables, all of them if latest is negative
+func Print(w io.Writer, r Report, blocks []Block, latest int) (err error) {
+	if r.Blocks < 1 {
+		_, err = fmt.Fprintln(w, "no blocks have been found")
+		return
+	}
+	if _, err = fmt.Fprintf(w, "%d blocks paying %v from %v to %v, %v"+
+		" apart on average\n\n", r.Blocks, util.Amount(r.Coinbase),
+		r.First.Format(time.RFC3339), r.Last.Format(time.RFC3339),
+		r.Interval.Round(time.Millisecond)); err != nil {
+		return
+	}
+	for _, t := range []struct {
+		title  string
+		totals []Total
+	}{{"algorithm", r.ByAlgo}, {"machine", r.ByMachine}} {
+		if err = printTotals(w, t.title, t.totals); err != nil {
+			return
+		}
+	}
+	if latest < 0 || latest > len(blocks) {
+		latest = len(blocks)
+	}
+	if latest < 1 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if _, err = fmt.Fprintln(tw, "height\talgorithm\tbits\tcoinbase\t"+
+		"since prev\ttemplate age\tmachine\thash\t"); err != nil {
+		return
+	}
+	for _, b := range blocks[len(blocks)-latest:] {
+		if _, err = fmt.Fprintf(tw, "%d\t%s\t%08x\t%v\t%v\t%v\t%s\t%s\t\n",
+			b.Height, b.Algo, b.Bits, util.Amount(b.Coinbase), b.SincePrev,
+			b.TemplateAge.Round(time.Millisecond), b.Miner,
+			b.Hash); err != nil {
+			return
+		}
+	}
+	return tw.Flush()
+}
+
+func printTotals(w io.Writer, title string, totals []Total) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if _, err = fmt.Fprintf(tw, "%s\tblocks\tshare\tcoinbase\t"+
+		"template age\tlast\t\n", title); err != nil {
+		return
+	}
+	for _, t := range totals {
+		if _, err = fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%v\t%v\t%s\t\n", t.Name,
+			t.Blocks, t.Share*100, util.Amount(t.Coinbase),
+			t.TemplateAge.Round(time.Millisecond),
+			t.Last.Format(time.RFC3339)); err != nil {
+			return
+		}
+	}
+	if err = tw.Flush(); err != nil {
+		return
+	}
+	_, err = fmt.Fprintln(w)
+	return
+}
@@ -0,0 +1,94 @@
+// Package identity is a Simplebuffer field that names the kopach machine a
+// message came from. It is appended to the end of hashrate reports,
+// solutions and heartbeats so that controllers can tell rigs apart even when
+// they have several network interfaces or their addresses change.
+package identity
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Identity describes a kopach machine
+type Identity struct {
+	// ID is a stable random identifier generated on the first run
+	ID string
+	// Name is set by the operator to tell their machines apart
+	Name string
+	// Version is the version of kopach running on the machine
+	Version string
+	// Threads is the number of workers the machine is running
+	Threads int32
+}
+
+func New() *Identity {
+	return &Identity{}
+}
+
+func (i *Identity) DecodeOne(b []byte) *Identity {
+	i.Decode(b)
+	return i
+}
+
+// Decode reads the identity from the head of the slice, each string is
+// prefixed by a one byte length and the thread count is last
+func (i *Identity) Decode(b []byte) (out []byte) {
+	var fields [3]string
+	for f := range fields {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return
+		}
+		fields[f] = string(b[1 : 1+int(b[0])])
+		b = b[1+int(b[0]):]
+	}
+	if len(b) < 4 {
+		return
+	}
+	i.ID, i.Name, i.Version = fields[0], fields[1], fields[2]
+	i.Threads = int32(binary.BigEndian.Uint32(b[:4]))
+	if len(b) > 4 {
+		out = b[4:]
+	}
+	return
+}
+
+// Validate checks a field holds one identity and nothing else
+func Validate(b []byte) error {
+	for f := 0; f < 3; f++ {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return errors.New("identity is cut short")
+		}
+		b = b[1+int(b[0]):]
+	}
+	if len(b) != 4 {
+		return fmt.Errorf("identity thread count is %d bytes", len(b))
+	}
+	return nil
+}
+
+func (i *Identity) Encode() (out []byte) {
+	for _, s := range []string{i.ID, i.Name, i.Version} {
+		if len(s) > 255 {
+			s = s[:255]
+		}
+		out = append(append(out, byte(len(s))), s...)
+	}
+	t := make([]byte, 4)
+	binary.BigEndian.PutUint32(t, uint32(i.Threads))
+	return append(out, t...)
+}
+
+func (i *Identity) Get() Identity {
+	return *i
+}
+
+func (i *Identity) Put(id Identity) *Identity {
+	*i = id
+	return i
+}
+
+func (i Identity) String() string {
+	return fmt.Sprintf("%s (%s) kopach %s %d threads", i.Name, i.ID,
+		i.Version, i.Threads)
+}
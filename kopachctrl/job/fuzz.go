@@ -0,0 +1,16 @@
+//go:build gofuzz
+// +build gofuzz
+
+package job
+
+// Fuzz is the go-fuzz entry point for job messages, any message Load accepts
+// must decode without panicking
+func Fuzz(data []byte) int {
+	j, err := Load(data)
+	if err != nil {
+		return 0
+	}
+	_ = j.Struct()
+	_ = j.String()
+	return 1
+}
@@ -6,7 +6,6 @@ import (
 	"sort"
 	"time"
 
-	log "github.com/p9c/logi"
 	"github.com/p9c/simplebuffer"
 	"github.com/p9c/simplebuffer/Bitses"
 	"github.com/p9c/simplebuffer/Hash"
@@ -15,17 +14,38 @@ import (
 	"github.com/p9c/simplebuffer/Int32"
 	"github.com/p9c/simplebuffer/Uint16"
 
+	"github.com/p9c/chaincfg/netparams"
 	"github.com/p9c/chainhash"
 	"github.com/p9c/fork"
 	"github.com/p9c/util"
 	"github.com/p9c/wire"
 
 	blockchain "github.com/p9c/chain"
-	"github.com/p9c/pod/pkg/conte"
+
+	"github.com/p9c/kopach/kopachctrl/protocol"
 )
 
 var Magic = []byte{'w', 'o', 'r', 'k'}
 
+// Schema describes the fields of a job
+var Schema = &protocol.Schema{
+	Name:  "job",
+	Magic: Magic,
+	Fields: []protocol.Field{
+		{Name: "IPs", Kind: protocol.IPs},
+		{Name: "P2PListenersPort", Kind: protocol.Uint16},
+		{Name: "RPCListenersPort", Kind: protocol.Uint16},
+		{Name: "ControllerListenerPort", Kind: protocol.Uint16},
+		{Name: "Height", Kind: protocol.Int32},
+		{Name: "PrevBlockHash", Kind: protocol.Hash},
+		{Name: "Bitses", Kind: protocol.Bitses},
+		{Name: "Hashes", Kind: protocol.Hashes},
+		{Name: "Protocol", Kind: protocol.Protocol, Since: 1},
+	},
+	Required:  8,
+	VersionAt: 8,
+}
+
 type Container struct {
 	simplebuffer.Container
 }
@@ -52,43 +72,18 @@ type Job struct {
 // copying memory, or deserialize their contents which will be concurrent safe
 // The varying coinbase payment values are in transaction 0 last output,
 // the individual varying transactions are stored separately and will be
-// reassembled at the end
-func Get(cx *conte.Xt, mB *util.Block, msg simplebuffer.Serializers, cbs *map[int32]*util.Tx) (out Container, txr []*util.Tx) {
-	// msg := append(Serializers{}, GetMessageBase(cx)...)
+// reassembled at the end. The job is for the block at height bH with the
+// targets in bitsMap.
+func Get(params *netparams.Params, bH int32, bitsMap blockchain.TargetBits,
+	mB *util.Block, msg simplebuffer.Serializers,
+	cbs *map[int32]*util.Tx) (out Container, txr []*util.Tx) {
 	if txr == nil {
 		txr = []*util.Tx{}
 	}
-	bH := cx.RealNode.Chain.BestSnapshot().Height + 1
 	nBH := Int32.New().Put(bH)
 	msg = append(msg, nBH)
 	mH := Hash.New().Put(mB.MsgBlock().Header.PrevBlock)
 	msg = append(msg, mH)
-	tip := cx.RealNode.Chain.BestChain.Tip()
-	// // this should be the same as the block in the notification
-	// tth := tip.Header()
-	// tH := &tth
-	// tbh := tH.BlockHash()
-	// if tbh.IsEqual(mB.Hash()) {
-	//	log.L.Debug("notification block is tip block")
-	// } else {
-	//	log.L.Debug("notification block is not tip block")
-	// }
-	bitsMap := make(blockchain.TargetBits)
-	var err error
-	df := tip.Diffs.Load().(blockchain.TargetBits)
-	if df == nil ||
-		len(df) != len(fork.List[1].AlgoVers) {
-		bitsMap, err = cx.RealNode.Chain.
-			CalcNextRequiredDifficultyPlan9Controller(tip)
-		if err != nil {
-			log.L.Error(err)
-			return
-		}
-		tip.Diffs.Store(bitsMap)
-	} else {
-		bitsMap = tip.Diffs.Load().(blockchain.TargetBits)
-	}
-	// log.L.Traces(*bitsMap)
 	bitses := Bitses.NewBitses()
 	bitses.Put(bitsMap)
 	msg = append(msg, bitses)
@@ -99,18 +94,16 @@ func Get(cx *conte.Xt, mB *util.Block, msg simplebuffer.Serializers, cbs *map[in
 	var val int64
 	mTS := make(map[int32]*chainhash.Hash)
 	txs := mB.Transactions()[0]
-	for i, v := range mB.Transactions()[1:] {
-		txr[i] = v
-	}
+	txr = append(txr, mB.Transactions()[1:]...)
 	nbH := bH
-	if (cx.ActiveNet.Net == wire.MainNet &&
+	if (params.Net == wire.MainNet &&
 		nbH == fork.List[1].ActivationHeight) ||
-		(cx.ActiveNet.Net == wire.TestNet3 &&
+		(params.Net == wire.TestNet3 &&
 			nbH == fork.List[1].TestnetStart) {
 		nbH++
 	}
 	for i := range bitsMap {
-		val = blockchain.CalcBlockSubsidy(nbH, cx.ActiveNet, i)
+		val = blockchain.CalcBlockSubsidy(nbH, params, i)
 		txc := txs.MsgTx().Copy()
 		txc.TxOut[len(txc.TxOut)-1].Value = val
 		txx := util.NewTx(txc.Copy())
@@ -126,7 +119,7 @@ func Get(cx *conte.Xt, mB *util.Block, msg simplebuffer.Serializers, cbs *map[in
 	// log.L.Traces(mTS)
 	mHashes := Hashes.NewHashes()
 	mHashes.Put(mTS)
-	msg = append(msg, mHashes)
+	msg = append(msg, mHashes, protocol.NewStamp())
 	// previously were sending blocks, no need for that really miner only needs
 	// valid block headers
 	// txs := mB.MsgBlock().Transactions
@@ -145,6 +138,25 @@ func LoadContainer(b []byte) (out Container) {
 	return
 }
 
+// Load checks a message has all the fields of a job and that each can be
+// decoded, and loads it into a container
+func Load(b []byte) (out Container, err error) {
+	if _, err = Schema.Check(b); err != nil {
+		return
+	}
+	out.Data = b
+	// workers build a header for every version with a target, which needs
+	// the merkle root of that version
+	hashes := out.GetHashes()
+	for version := range out.GetBitses() {
+		if _, ok := hashes[version]; !ok {
+			return Container{}, fmt.Errorf("job has a target for version"+
+				" %d but no merkle root", version)
+		}
+	}
+	return
+}
+
 func (j *Container) GetIPs() []*net.IP {
 	return IPs.New().DecodeOne(j.Get(0)).Get()
 }
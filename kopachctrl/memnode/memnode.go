@@ -0,0 +1,299 @@
+// Package memnode is an in-memory chain that stands in for a pod node, so a
+// controller and miners can be run without one, such as in tests and
+// simulations. It checks the previous block, merkle root, coinbase value and
+// proof of work of the blocks it is given, and nothing else.
+package memnode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/p9c/chaincfg/netparams"
+	"github.com/p9c/chainhash"
+	"github.com/p9c/fork"
+	"github.com/p9c/util"
+	"github.com/p9c/wire"
+
+	blockchain "github.com/p9c/chain"
+	"github.com/p9c/chain/mining"
+
+	"github.com/p9c/kopach/kopachctrl"
+)
+
+// PayToScript is the output script of the coinbases, anyone can spend it
+var PayToScript = []byte{0x51}
+
+// Node is an in-memory chain implementing all of a controller Backend
+type Node struct {
+	mx         sync.Mutex
+	params     *netparams.Params
+	blocks     []*util.Block
+	targets    blockchain.TargetBits
+	subs       []blockchain.NotificationCallback
+	txUpdated  time.Time
+	current    bool
+	extraNonce uint64
+	peers      []string
+	otherNodes int32
+}
+
+// New creates a chain holding the genesis block of the network, it is
+// current so a controller starts sending jobs at once
+func New(params *netparams.Params) *Node {
+	genesis := util.NewBlock(params.GenesisBlock)
+	genesis.SetHeight(0)
+	return &Node{
+		params:    params,
+		blocks:    []*util.Block{genesis},
+		txUpdated: time.Now(),
+		current:   true,
+	}
+}
+
+// Backend returns the node as the backend of a controller
+func (n *Node) Backend() kopachctrl.Backend {
+	return kopachctrl.Backend{
+		Templates:  n,
+		Chain:      n,
+		Difficulty: n,
+		Blocks:     n,
+		Peers:      n,
+	}
+}
+
+// SetTargets fixes the targets of the following blocks, with nil they are
+// the proof of work limit of the network
+func (n *Node) SetTargets(bits blockchain.TargetBits) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.targets = bits
+}
+
+// SetCurrent changes whether the chain counts as synced
+func (n *Node) SetCurrent(current bool) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.current = current
+}
+
+// Height returns the height of the tip
+func (n *Node) Height() int32 {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return int32(len(n.blocks) - 1)
+}
+
+// Peers returns the addresses the controller asked the node to connect to
+// and the number of other nodes it reported
+func (n *Node) Peers() (peers []string, otherNodes int32) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return append([]string{}, n.peers...), n.otherNodes
+}
+
+// NewBlockTemplate returns a template with only a coinbase, paying the
+// subsidy of the next block to PayToScript
+func (n *Node) NewBlockTemplate() (template *mining.BlockTemplate,
+	err error) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	tip := n.blocks[len(n.blocks)-1]
+	height := tip.Height() + 1
+	bits := n.nextTargets(height)
+	version := fork.GetAlgoVer(fork.SHA256d, height)
+	n.extraNonce++
+	script := make([]byte, 14)
+	script[0], script[5] = 4, 8
+	binary.LittleEndian.PutUint32(script[1:], uint32(height))
+	binary.LittleEndian.PutUint64(script[6:], n.extraNonce)
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: script,
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(&wire.TxOut{
+		Value:    blockchain.CalcBlockSubsidy(height, n.params, version),
+		PkScript: PayToScript,
+	})
+	timestamp := time.Now()
+	prevTime := tip.MsgBlock().Header.Timestamp
+	if !timestamp.After(prevTime) {
+		timestamp = prevTime.Add(time.Second)
+	}
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    version,
+			PrevBlock:  *tip.Hash(),
+			MerkleRoot: coinbase.TxHash(),
+			Timestamp:  time.Unix(timestamp.Unix(), 0),
+			Bits:       bits[version],
+		},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+	return &mining.BlockTemplate{
+		Block:           msgBlock,
+		Fees:            []int64{0},
+		SigOpCosts:      []int64{0},
+		Height:          height,
+		ValidPayAddress: true,
+	}, nil
+}
+
+// TxLastUpdated returns when the node was created as it has no mempool
+func (n *Node) TxLastUpdated() time.Time {
+	return n.txUpdated
+}
+
+// BestSnapshot returns the state of the tip
+func (n *Node) BestSnapshot() *blockchain.BestState {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	tip := n.blocks[len(n.blocks)-1]
+	h := tip.MsgBlock().Header
+	return &blockchain.BestState{
+		Hash:       *tip.Hash(),
+		Height:     tip.Height(),
+		Version:    h.Version,
+		Bits:       h.Bits,
+		NumTxns:    uint64(len(tip.Transactions())),
+		MedianTime: h.Timestamp,
+	}
+}
+
+// IsCurrent returns whether the chain counts as synced
+func (n *Node) IsCurrent() bool {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.current
+}
+
+// Subscribe registers a callback told of every block connected
+func (n *Node) Subscribe(callback blockchain.NotificationCallback) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.subs = append(n.subs, callback)
+}
+
+// NextTargets returns the targets of the block after the tip
+func (n *Node) NextTargets() (blockchain.TargetBits, error) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.nextTargets(int32(len(n.blocks))), nil
+}
+
+func (n *Node) nextTargets(height int32) (bits blockchain.TargetBits) {
+	bits = make(blockchain.TargetBits)
+	if n.targets != nil {
+		for ver, b := range n.targets {
+			bits[ver] = b
+		}
+		return
+	}
+	for ver := range fork.List[fork.GetCurrent(height)].AlgoVers {
+		bits[ver] = n.params.PowLimitBits
+	}
+	return
+}
+
+// ProcessBlock connects a block to the tip if it is valid
+func (n *Node) ProcessBlock(block *util.Block) (isOrphan bool, err error) {
+	n.mx.Lock()
+	tip := n.blocks[len(n.blocks)-1]
+	height := tip.Height() + 1
+	msgBlock := block.MsgBlock()
+	h := &msgBlock.Header
+	if !h.PrevBlock.IsEqual(tip.Hash()) {
+		n.mx.Unlock()
+		return true, nil
+	}
+	if err = n.check(block, height); err != nil {
+		n.mx.Unlock()
+		return
+	}
+	block.SetHeight(height)
+	n.blocks = append(n.blocks, block)
+	subs := append([]blockchain.NotificationCallback{}, n.subs...)
+	n.mx.Unlock()
+	for _, callback := range subs {
+		callback(&blockchain.Notification{
+			Type: blockchain.NTBlockConnected,
+			Data: block,
+		})
+	}
+	return
+}
+
+// check checks the merkle root, coinbase and proof of work of a block
+func (n *Node) check(block *util.Block, height int32) (err error) {
+	msgBlock := block.MsgBlock()
+	h := &msgBlock.Header
+	if len(msgBlock.Transactions) < 1 {
+		return ruleError(blockchain.ErrNoTransactions, "block has no"+
+			" transactions")
+	}
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
+	if !h.MerkleRoot.IsEqual(merkles[len(merkles)-1]) {
+		return ruleError(blockchain.ErrBadMerkleRoot, "merkle root does"+
+			" not match the transactions")
+	}
+	var value int64
+	for _, out := range msgBlock.Transactions[0].TxOut {
+		value += out.Value
+	}
+	subsidy := blockchain.CalcBlockSubsidy(height, n.params, h.Version)
+	if value != subsidy {
+		return ruleError(blockchain.ErrBadCoinbaseValue,
+			fmt.Sprintf("coinbase pays %d, the subsidy is %d", value,
+				subsidy))
+	}
+	bits, ok := n.nextTargets(height)[h.Version]
+	if !ok {
+		return ruleError(blockchain.ErrUnexpectedDifficulty,
+			fmt.Sprintf("version %d is not mined at height %d", h.Version,
+				height))
+	}
+	if h.Bits != bits {
+		return ruleError(blockchain.ErrUnexpectedDifficulty,
+			fmt.Sprintf("bits %08x are not the target %08x", h.Bits, bits))
+	}
+	hash := h.BlockHashWithAlgos(height)
+	if blockchain.HashToBig(&hash).Cmp(fork.CompactToBig(bits)) > 0 {
+		return ruleError(blockchain.ErrHighHash, fmt.Sprintf("hash %v is"+
+			" above the target %08x", hash, bits))
+	}
+	return
+}
+
+// BlockByHeight returns the block at a height
+func (n *Node) BlockByHeight(height int32) (*util.Block, error) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	if height < 0 || int(height) >= len(n.blocks) {
+		return nil, errors.New("no block at that height")
+	}
+	return n.blocks[height], nil
+}
+
+// Connect records the address of a peer
+func (n *Node) Connect(addr string) error {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.peers = append(n.peers, addr)
+	return nil
+}
+
+// SetOtherNodes records the number of other nodes on the LAN
+func (n *Node) SetOtherNodes(count int32) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.otherNodes = count
+}
+
+func ruleError(code blockchain.ErrorCode, desc string) error {
+	return blockchain.RuleError{ErrorCode: code, Description: desc}
+}
@@ -0,0 +1,176 @@
+// Package message is the registry of the kopach wire messages. It holds the
+// schema of every message type and makes the transport handlers the
+// controllers and miners listen with from typed callbacks, so every message
+// is checked against its schema before a callback sees it.
+package message
+
+import (
+	"bytes"
+	"net"
+	"sync"
+
+	log "github.com/p9c/logi"
+	"github.com/p9c/transport"
+
+	"github.com/p9c/kopach/kopachctrl/hashrate"
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/kopachctrl/job"
+	"github.com/p9c/kopach/kopachctrl/p2padvt"
+	"github.com/p9c/kopach/kopachctrl/pause"
+	"github.com/p9c/kopach/kopachctrl/protocol"
+	"github.com/p9c/kopach/kopachctrl/settings"
+	"github.com/p9c/kopach/kopachctrl/sol"
+)
+
+// Schemas are the schemas of all the message types
+var Schemas = []*protocol.Schema{
+	job.Schema,
+	sol.Schema,
+	pause.Schema,
+	p2padvt.Schema,
+	hashrate.Schema,
+	heartbeat.Schema,
+	settings.Schema,
+}
+
+// Lookup returns the schema of the message type with the magic
+func Lookup(magic []byte) (s *protocol.Schema, ok bool) {
+	for _, s = range Schemas {
+		if bytes.Equal(s.Magic, magic) {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Meta is what is known about a message apart from its contents
+type Meta struct {
+	Src net.Addr
+	// Version is the protocol version of the sender, 0 if it is from before
+	// versioning began
+	Version byte
+}
+
+// Handlers are the callbacks for each message type, the types with nil
+// callbacks are not listened for
+type Handlers struct {
+	Job          func(ctx interface{}, m Meta, j job.Container) error
+	Solution     func(ctx interface{}, m Meta, s *sol.SolContainer) error
+	Pause        func(ctx interface{}, m Meta, p *pause.PauseContainer) error
+	Advertisment func(ctx interface{}, m Meta, a p2padvt.Container) error
+	Hashrate     func(ctx interface{}, m Meta, h hashrate.Container) error
+	Heartbeat    func(ctx interface{}, m Meta, h heartbeat.Container) error
+	Settings     func(ctx interface{}, m Meta, s settings.Container) error
+	// Rejected is called with the name of the message type for each message
+	// of a type listened for that does not match its schema, as happens
+	// when it is garbled or is from a sender with another miner password
+	Rejected func(ctx interface{}, m Meta, name string, err error)
+}
+
+// Transport returns the transport handlers for the callbacks that are set.
+// Messages that do not match their schema are dropped with an error.
+func (h Handlers) Transport() (th transport.Handlers) {
+	th = make(transport.Handlers)
+	if h.Job != nil {
+		th[string(job.Magic)] = func(ctx interface{}, src net.Addr,
+			dst string, b []byte) (err error) {
+			var j job.Container
+			if j, err = job.Load(b); err != nil {
+				h.reject(ctx, job.Schema, src, err)
+				return
+			}
+			return h.Job(ctx, meta(job.Schema, src, b), j)
+		}
+	}
+	if h.Solution != nil {
+		th[string(sol.SolutionMagic)] = func(ctx interface{}, src net.Addr,
+			dst string, b []byte) (err error) {
+			var s *sol.SolContainer
+			if s, err = sol.Load(b); err != nil {
+				h.reject(ctx, sol.Schema, src, err)
+				return
+			}
+			return h.Solution(ctx, meta(sol.Schema, src, b), s)
+		}
+	}
+	if h.Pause != nil {
+		th[string(pause.PauseMagic)] = func(ctx interface{}, src net.Addr,
+			dst string, b []byte) (err error) {
+			var p *pause.PauseContainer
+			if p, err = pause.Load(b); err != nil {
+				h.reject(ctx, pause.Schema, src, err)
+				return
+			}
+			return h.Pause(ctx, meta(pause.Schema, src, b), p)
+		}
+	}
+	if h.Advertisment != nil {
+		th[string(p2padvt.Magic)] = func(ctx interface{}, src net.Addr,
+			dst string, b []byte) (err error) {
+			var a p2padvt.Container
+			if a, err = p2padvt.Load(b); err != nil {
+				h.reject(ctx, p2padvt.Schema, src, err)
+				return
+			}
+			return h.Advertisment(ctx, meta(p2padvt.Schema, src, b), a)
+		}
+	}
+	if h.Hashrate != nil {
+		th[string(hashrate.HashrateMagic)] = func(ctx interface{},
+			src net.Addr, dst string, b []byte) (err error) {
+			var hr hashrate.Container
+			if hr, err = hashrate.Load(b); err != nil {
+				h.reject(ctx, hashrate.Schema, src, err)
+				return
+			}
+			return h.Hashrate(ctx, meta(hashrate.Schema, src, b), hr)
+		}
+	}
+	if h.Heartbeat != nil {
+		th[string(heartbeat.Magic)] = func(ctx interface{}, src net.Addr,
+			dst string, b []byte) (err error) {
+			var hb heartbeat.Container
+			if hb, err = heartbeat.Load(b); err != nil {
+				h.reject(ctx, heartbeat.Schema, src, err)
+				return
+			}
+			return h.Heartbeat(ctx, meta(heartbeat.Schema, src, b), hb)
+		}
+	}
+	if h.Settings != nil {
+		th[string(settings.Magic)] = func(ctx interface{}, src net.Addr,
+			dst string, b []byte) (err error) {
+			var s settings.Container
+			if s, err = settings.Load(b); err != nil {
+				h.reject(ctx, settings.Schema, src, err)
+				return
+			}
+			return h.Settings(ctx, meta(settings.Schema, src, b), s)
+		}
+	}
+	return
+}
+
+func (h Handlers) reject(ctx interface{}, s *protocol.Schema, src net.Addr,
+	err error) {
+	if h.Rejected != nil {
+		h.Rejected(ctx, Meta{Src: src}, s.Name, err)
+	}
+}
+
+// newer is the message types and versions from newer senders that have
+// been logged
+var newer sync.Map
+
+func meta(s *protocol.Schema, src net.Addr, b []byte) (m Meta) {
+	m = Meta{Src: src, Version: s.VersionOf(b)}
+	if m.Version > protocol.Version {
+		key := string(s.Magic) + string(m.Version)
+		if _, seen := newer.LoadOrStore(key, true); !seen {
+			log.L.Infof("%s messages from %v are protocol version %d, the"+
+				" fields added since version %d are ignored", s.Name, src,
+				m.Version, protocol.Version)
+		}
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package kopachctrl
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/p9c/fork"
+
+	"github.com/p9c/kopach/kopachctrl/message"
+	"github.com/p9c/kopach/metrics"
+)
+
+// The kinds of job broadcasts
+const (
+	// NewJob is a job for a new template and Rebroadcast the current job
+	// sent again for miners that missed it
+	NewJob      = "new"
+	Rebroadcast = "rebroadcast"
+)
+
+// controllerMetrics are the metrics of a controller
+type controllerMetrics struct {
+	registry        *metrics.Registry
+	jobs            *metrics.Counter
+	templates       *metrics.Counter
+	templateSeconds *metrics.Histogram
+	solutions       *metrics.Counter
+	pauses          *metrics.Counter
+	rejected        *metrics.Counter
+}
+
+func newControllerMetrics(c *Controller) (m *controllerMetrics) {
+	m = &controllerMetrics{
+		registry: metrics.NewRegistry(),
+		jobs: metrics.NewCounter("kopach_controller_jobs_sent_total",
+			"Jobs broadcast to the miners.", "kind"),
+		templates: metrics.NewCounter("kopach_controller_templates_total",
+			"Block templates built for jobs.", "result"),
+		templateSeconds: metrics.NewHistogram(
+			"kopach_controller_template_seconds",
+			"Time taken to build a block template and its job.", nil),
+		solutions: metrics.NewCounter("kopach_controller_solutions_total",
+			"Solutions received from the miners by outcome.", "outcome"),
+		pauses: metrics.NewCounter("kopach_controller_pauses_sent_total",
+			"Pause messages broadcast to the miners."),
+		rejected: metrics.NewCounter(
+			"kopach_controller_decrypt_failures_total",
+			"Messages received that did not decrypt into a valid message"+
+				" of their type.", "type"),
+	}
+	m.registry.Register(m.jobs, m.templates, m.templateSeconds, m.solutions,
+		m.pauses, m.rejected,
+		metrics.NewFunc("kopach_controller_height",
+			"Height of the block the current job is for.",
+			metrics.GaugeType, func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(c.height.Load())}}
+			}),
+		metrics.NewFunc("kopach_controller_ready",
+			"Whether the chain is current and jobs are being sent.",
+			metrics.GaugeType, func() []metrics.Sample {
+				v := 0.0
+				if c.Ready.Load() {
+					v = 1
+				}
+				return []metrics.Sample{{Value: v}}
+			}),
+		metrics.NewFunc("kopach_controller_hashrate",
+			"Hashes per second reported by all the miners.",
+			metrics.GaugeType, func() []metrics.Sample {
+				return []metrics.Sample{{Value: c.hashrate.Load()}}
+			}),
+		metrics.NewFunc("kopach_controller_miner_hashes_total",
+			"Hashes reported by each miner for each algorithm.",
+			metrics.CounterType, c.minerHashes),
+		metrics.NewFunc("kopach_controller_miners",
+			"Miners that have been heard from by whether they are silent.",
+			metrics.GaugeType, c.minerCounts),
+	)
+	return
+}
+
+// minerHashes returns the hash counts of each miner by algorithm
+func (c *Controller) minerHashes() (out []metrics.Sample) {
+	height := int32(c.height.Load())
+	for _, m := range c.registry.List() {
+		counts := make(map[string]uint64)
+		for v, n := range m.HashCounts {
+			counts[fork.GetAlgoName(v, height)] += n
+		}
+		algos := make([]string, 0, len(counts))
+		for algo := range counts {
+			algos = append(algos, algo)
+		}
+		sort.Strings(algos)
+		for _, algo := range algos {
+			out = append(out, metrics.Sample{
+				Labels: []string{"miner", m.Name, "id", m.ID, "algo", algo},
+				Value:  float64(counts[algo]),
+			})
+		}
+	}
+	return
+}
+
+// minerCounts returns how many miners are silent and not
+func (c *Controller) minerCounts() []metrics.Sample {
+	var active, silent float64
+	for _, m := range c.registry.List() {
+		if m.Silent {
+			silent++
+		} else {
+			active++
+		}
+	}
+	return []metrics.Sample{
+		{Labels: []string{"silent", "false"}, Value: active},
+		{Labels: []string{"silent", "true"}, Value: silent},
+	}
+}
+
+// templateBuilt records the result and time taken of a template build
+func (m *controllerMetrics) templateBuilt(start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.templates.Inc(result)
+	m.templateSeconds.Observe(time.Since(start).Seconds())
+}
+
+// Metrics returns the metrics of the controller, which are also served at
+// /metrics by the StatusHandler
+func (c *Controller) Metrics() *metrics.Registry {
+	return c.metrics.registry
+}
+
+// MetricsHandler serves the metrics of the controller to a scraper
+func (c *Controller) MetricsHandler() http.Handler {
+	return c.metrics.registry
+}
+
+// rejected counts the messages that do not match their schema
+func rejected(ctx interface{}, msg message.Meta, name string, err error) {
+	c := ctx.(*Controller)
+	c.metrics.rejected.Inc(name)
+}
@@ -0,0 +1,156 @@
+package kopachctrl
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/fork"
+	"github.com/p9c/util"
+
+	blockchain "github.com/p9c/chain"
+	"github.com/p9c/chain/mining"
+
+	"github.com/p9c/pod/pkg/conte"
+
+	"github.com/p9c/kopach/config"
+	"github.com/p9c/kopach/kopachctrl/history"
+)
+
+// Run starts a controller for a pod node, it returns when the controller
+// stops
+func Run(cx *conte.Xt) (quit chan struct{}) {
+	if len(cx.StateCfg.ActiveMiningAddrs) < 1 {
+		log.L.Warn("no mining addresses, not starting controller")
+		return
+	}
+	if len(*cx.Config.RPCListeners) < 1 || *cx.Config.DisableRPC {
+		log.L.Warn("not running controller without RPC enabled")
+		return
+	}
+	if len(*cx.Config.Listeners) < 1 || *cx.Config.DisableListen {
+		log.L.Warn("not running controller without p2p listener enabled")
+		return
+	}
+	cfg := NodeConfig(cx)
+	cfg.BlocksFile = history.Path(*cx.Config.DataDir, cx.ActiveNet.Name)
+	if kc, err := config.Load(*cx.Config.DataDir); err != nil {
+		log.L.Error(err)
+	} else {
+		cfg.ControlKey = kc.ControlKey
+		cfg.StatusListener = kc.StatusListener
+	}
+	ctrl := New(cfg, NewNodeBackend(cx))
+	quit = ctrl.quit
+	if err := ctrl.Run(); err != nil {
+		log.L.Error(err)
+	}
+	return
+}
+
+// node is the Backend of a pod node
+type node struct {
+	cx        *conte.Xt
+	generator *mining.BlkTmplGenerator
+}
+
+// NewNodeBackend returns the Backend for a controller running in a pod node
+func NewNodeBackend(cx *conte.Xt) Backend {
+	n := &node{cx: cx, generator: getBlkTemplateGenerator(cx)}
+	return Backend{
+		Templates:  n,
+		Chain:      n,
+		Difficulty: n,
+		Blocks:     n,
+		Peers:      n,
+	}
+}
+
+// NodeConfig returns the controller configuration of a pod node
+func NodeConfig(cx *conte.Xt) Config {
+	return Config{
+		Params:      cx.ActiveNet,
+		Pass:        *cx.Config.MinerPass,
+		P2PListener: (*cx.Config.Listeners)[0],
+		RPCListener: (*cx.Config.RPCListeners)[0],
+		Controller:  *cx.Config.Controller,
+	}
+}
+
+func (n *node) NewBlockTemplate() (template *mining.BlockTemplate,
+	err error) {
+	log.L.Trace("getting new block template")
+	if len(*n.cx.Config.MiningAddrs) < 1 {
+		return nil, errors.New("no mining addresses")
+	}
+	// Choose a payment address at random.
+	rand.Seed(time.Now().UnixNano())
+	payToAddr := n.cx.StateCfg.ActiveMiningAddrs[rand.Intn(len(*n.cx.Config.
+		MiningAddrs))]
+	log.L.Trace("calling new block template")
+	return n.generator.NewBlockTemplate(0, payToAddr, fork.SHA256d)
+}
+
+func (n *node) TxLastUpdated() time.Time {
+	return n.generator.GetTxSource().LastUpdated()
+}
+
+func (n *node) BestSnapshot() *blockchain.BestState {
+	return n.cx.RealNode.Chain.BestSnapshot()
+}
+
+func (n *node) IsCurrent() bool {
+	return n.cx.IsCurrent()
+}
+
+func (n *node) Subscribe(callback blockchain.NotificationCallback) {
+	n.cx.RealNode.Chain.Subscribe(callback)
+}
+
+// NextTargets computes the targets once per tip and keeps them in the tip
+func (n *node) NextTargets() (bitsMap blockchain.TargetBits, err error) {
+	tip := n.cx.RealNode.Chain.BestChain.Tip()
+	df, _ := tip.Diffs.Load().(blockchain.TargetBits)
+	if df != nil && len(df) == len(fork.List[1].AlgoVers) {
+		return df, nil
+	}
+	if bitsMap, err = n.cx.RealNode.Chain.
+		CalcNextRequiredDifficultyPlan9Controller(tip); err != nil {
+		return
+	}
+	tip.Diffs.Store(bitsMap)
+	return
+}
+
+func (n *node) ProcessBlock(block *util.Block) (isOrphan bool, err error) {
+	return n.cx.RealNode.SyncManager.ProcessBlock(block, blockchain.BFNone)
+}
+
+func (n *node) BlockByHeight(height int32) (*util.Block, error) {
+	return n.cx.RealNode.Chain.BlockByHeight(height)
+}
+
+func (n *node) Connect(addr string) error {
+	return n.cx.RPCServer.Cfg.ConnMgr.Connect(addr, true)
+}
+
+func (n *node) SetOtherNodes(count int32) {
+	n.cx.OtherNodes.Store(count)
+}
+
+func getBlkTemplateGenerator(cx *conte.Xt) *mining.BlkTmplGenerator {
+	policy := mining.Policy{
+		BlockMinWeight:    uint32(*cx.Config.BlockMinWeight),
+		BlockMaxWeight:    uint32(*cx.Config.BlockMaxWeight),
+		BlockMinSize:      uint32(*cx.Config.BlockMinSize),
+		BlockMaxSize:      uint32(*cx.Config.BlockMaxSize),
+		BlockPrioritySize: uint32(*cx.Config.BlockPrioritySize),
+		TxMinFreeFee:      cx.StateCfg.ActiveMinRelayTxFee,
+	}
+	s := cx.RealNode
+	return mining.NewBlkTmplGenerator(&policy,
+		s.ChainParams, s.TxMemPool, s.Chain, s.TimeSource,
+		s.SigCache, s.HashCache, s.Algo)
+}
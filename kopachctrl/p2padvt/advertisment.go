@@ -1,13 +1,14 @@
 package p2padvt
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/p9c/simplebuffer"
 	"github.com/p9c/simplebuffer/IPs"
 	"github.com/p9c/simplebuffer/Uint16"
 
-	"github.com/p9c/pod/pkg/conte"
+	"github.com/p9c/kopach/kopachctrl/protocol"
 )
 
 var Magic = []byte{'a', 'd', 'v', 't'}
@@ -23,15 +24,70 @@ func LoadContainer(b []byte) (out Container) {
 	return
 }
 
-func Get(cx *conte.Xt) simplebuffer.Serializers {
+// Fields are the fields of an advertisment, which start pause messages and
+// jobs too
+var Fields = []protocol.Field{
+	{Name: "IPs", Kind: protocol.IPs},
+	{Name: "P2PListenersPort", Kind: protocol.Uint16},
+	{Name: "RPCListenersPort", Kind: protocol.Uint16},
+	{Name: "ControllerListenerPort", Kind: protocol.Uint16},
+}
+
+// Schema describes the fields of an advertisment
+var Schema = &protocol.Schema{
+	Name:  "advertisment",
+	Magic: Magic,
+	Fields: append(Fields[:len(Fields):len(Fields)],
+		protocol.Field{Name: "Protocol", Kind: protocol.Protocol, Since: 1}),
+	Required:  4,
+	VersionAt: 4,
+}
+
+// Load checks a message has all the fields of an advertisment and that each
+// can be decoded, and loads it into a container
+func Load(b []byte) (out Container, err error) {
+	if _, err = Schema.Check(b); err != nil {
+		return
+	}
+	out.Data = b
+	return
+}
+
+// Listenable returns the addresses a node advertises, its routable IPv4
+// addresses, or the loopback address if it has none as the receivers reject
+// messages without an address
+func Listenable() (ips []*net.IP) {
+	if l, ok := IPs.GetListenable().(*IPs.IPs); ok {
+		ips = l.Get()
+	}
+	if len(ips) < 1 {
+		loopback := net.IPv4(127, 0, 0, 1)
+		ips = []*net.IP{&loopback}
+	}
+	return
+}
+
+// Get returns the advertisment of a node with the given addresses and p2p,
+// RPC and controller listener addresses
+func Get(ips []*net.IP, p2pListener, rpcListener,
+	controller string) simplebuffer.Serializers {
 	return simplebuffer.Serializers{
-		IPs.GetListenable(),
-		Uint16.GetPort((*cx.Config.Listeners)[0]),
-		Uint16.GetPort((*cx.Config.RPCListeners)[0]),
-		Uint16.GetPort(*cx.Config.Controller),
+		IPs.New().Put(ips),
+		Uint16.GetPort(p2pListener),
+		Uint16.GetPort(rpcListener),
+		Uint16.GetPort(controller),
 	}
 }
 
+// GetContainer returns the advertisment of a node with the given addresses
+// and p2p, RPC and controller listener addresses ready to send
+func GetContainer(ips []*net.IP, p2pListener, rpcListener,
+	controller string) Container {
+	srs := append(Get(ips, p2pListener, rpcListener, controller),
+		protocol.NewStamp())
+	return Container{*srs.CreateContainer(Magic)}
+}
+
 func (j *Container) GetIPs() []*net.IP {
 	return IPs.New().DecodeOne(j.Get(0)).Get()
 }
@@ -47,3 +103,22 @@ func (j *Container) GetRPCListenersPort() uint16 {
 func (j *Container) GetControllerListenerPort() uint16 {
 	return Uint16.New().DecodeOne(j.Get(3)).Get()
 }
+
+func (j *Container) String() (s string) {
+	s += fmt.Sprint("\ntype '"+string(Magic)+"' elements:", j.Count())
+	s += "\n"
+	ips := j.GetIPs()
+	s += "1 IPs:"
+	for i := range ips {
+		s += fmt.Sprint(" ", ips[i].String())
+	}
+	s += "\n"
+	s += fmt.Sprint("2 P2PListenersPort: ", j.GetP2PListenersPort())
+	s += "\n"
+	s += fmt.Sprint("3 RPCListenersPort: ", j.GetRPCListenersPort())
+	s += "\n"
+	s += fmt.Sprint("4 ControllerListenerPort: ",
+		j.GetControllerListenerPort())
+	s += "\n"
+	return
+}
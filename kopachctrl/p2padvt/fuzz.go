@@ -0,0 +1,18 @@
+//go:build gofuzz
+// +build gofuzz
+
+package p2padvt
+
+// Fuzz is the go-fuzz entry point for advertisments, any message Load
+// accepts must decode without panicking
+func Fuzz(data []byte) int {
+	a, err := Load(data)
+	if err != nil {
+		return 0
+	}
+	_ = a.GetIPs()
+	_ = a.GetP2PListenersPort()
+	_ = a.GetRPCListenersPort()
+	_ = a.GetControllerListenerPort()
+	return 1
+}
@@ -0,0 +1,17 @@
+//go:build gofuzz
+// +build gofuzz
+
+package pause
+
+// Fuzz is the go-fuzz entry point for pause messages, any message Load
+// accepts must decode without panicking
+func Fuzz(data []byte) int {
+	p, err := Load(data)
+	if err != nil {
+		return 0
+	}
+	_ = p.GetP2PListeners()
+	_ = p.GetRPCListeners()
+	_ = p.GetControllerListener()
+	return 1
+}
@@ -1,6 +1,7 @@
 package pause
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/p9c/simplebuffer"
@@ -8,17 +9,32 @@ import (
 	"github.com/p9c/simplebuffer/Uint16"
 
 	"github.com/p9c/kopach/kopachctrl/p2padvt"
-	"github.com/p9c/pod/pkg/conte"
+	"github.com/p9c/kopach/kopachctrl/protocol"
 )
 
 var PauseMagic = []byte{'p', 'a', 'u', 's'}
 
+// Schema describes the fields of a pause, which are those of an
+// advertisment
+var Schema = &protocol.Schema{
+	Name:  "pause",
+	Magic: PauseMagic,
+	Fields: append(p2padvt.Fields[:len(p2padvt.Fields):len(p2padvt.Fields)],
+		protocol.Field{Name: "Protocol", Kind: protocol.Protocol, Since: 1}),
+	Required:  4,
+	VersionAt: 4,
+}
+
 type PauseContainer struct {
 	simplebuffer.Container
 }
 
-func GetPauseContainer(cx *conte.Xt) *PauseContainer {
-	mB := p2padvt.Get(cx).CreateContainer(PauseMagic)
+// GetPauseContainer returns a pause message from the node with the given
+// addresses and p2p, RPC and controller listener addresses
+func GetPauseContainer(ips []*net.IP, p2pListener, rpcListener,
+	controller string) *PauseContainer {
+	mB := append(p2padvt.Get(ips, p2pListener, rpcListener, controller),
+		protocol.NewStamp()).CreateContainer(PauseMagic)
 	return &PauseContainer{*mB}
 }
 
@@ -28,6 +44,15 @@ func LoadPauseContainer(b []byte) (out *PauseContainer) {
 	return
 }
 
+// Load checks a message has all the fields of a pause and that each can be
+// decoded, and loads it into a container
+func Load(b []byte) (out *PauseContainer, err error) {
+	if _, err = Schema.Check(b); err != nil {
+		return
+	}
+	return LoadPauseContainer(b), nil
+}
+
 func (mC *PauseContainer) GetIPs() []*net.IP {
 	return IPs.New().DecodeOne(mC.Get(0)).Get()
 }
@@ -69,3 +94,22 @@ func (mC *PauseContainer) GetControllerListener() (out []string) {
 	}
 	return
 }
+
+func (mC *PauseContainer) String() (s string) {
+	s += fmt.Sprint("\ntype '"+string(PauseMagic)+"' elements:", mC.Count())
+	s += "\n"
+	ips := mC.GetIPs()
+	s += "1 IPs:"
+	for i := range ips {
+		s += fmt.Sprint(" ", ips[i].String())
+	}
+	s += "\n"
+	s += fmt.Sprint("2 P2PListenersPort: ", mC.GetP2PListenersPort())
+	s += "\n"
+	s += fmt.Sprint("3 RPCListenersPort: ", mC.GetRPCListenersPort())
+	s += "\n"
+	s += fmt.Sprint("4 ControllerListenerPort: ",
+		mC.GetControllerListenerPort())
+	s += "\n"
+	return
+}
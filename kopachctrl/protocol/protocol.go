@@ -0,0 +1,179 @@
+// Package protocol describes the fields of the kopach wire messages and the
+// version of their formats, so that miners and controllers of different
+// versions can work together on one LAN while it is being upgraded.
+//
+// A message is a Simplebuffer container identified by its four byte magic,
+// and its fields are read by their position. The format of each message is
+// changed only by these rules:
+//
+//   - fields are never removed, reordered or given a different type. A change
+//     that cannot follow these rules needs a message with a new magic.
+//   - new fields are only added at the end of a message, with Since set to
+//     the protocol version that added them, and Version is raised.
+//   - readers check there are enough fields before reading an optional one,
+//     and ignore fields after the ones they know of.
+//
+// Each message type has a protocol version field at a fixed position after
+// the fields it had before versioning began, holding the Version of the
+// sender. Messages without it are from older senders and are version 0.
+// A message with a version must have every field up to and including that
+// version, while a message from a newer sender is read using the fields
+// this version knows of.
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/p9c/kopach/kopachctrl/identity"
+	"github.com/p9c/kopach/simplebuffer/validate"
+)
+
+// Version is the version of the message formats sent by this kopach
+const Version byte = 1
+
+// Kind is the type of a field and the check that it can be decoded
+type Kind struct {
+	Name  string
+	Check validate.Check
+}
+
+// The kinds of the fields of kopach messages
+var (
+	IPs       = Kind{"IPs", validate.IPs}
+	AnyIPs    = Kind{"IPs", validate.AnyIPs}
+	Uint16    = Kind{"Uint16", validate.Uint16}
+	Int32     = Kind{"Int32", validate.Int32}
+	Time      = Kind{"Time", validate.Time}
+	Hash      = Kind{"Hash", validate.Hash}
+	Bitses    = Kind{"Bitses", validate.Bitses}
+	Hashes    = Kind{"Hashes", validate.Hashes}
+	Block     = Kind{"Block", validate.Prefixed}
+	String    = Kind{"String", validate.String}
+	Strings   = Kind{"Strings", validate.Strings}
+	Counts    = Kind{"Counts", validate.Table(8)}
+	Identity  = Kind{"Identity", identity.Validate}
+	Signature = Kind{"Signature", validate.String}
+	Protocol  = Kind{"Version", checkVersion}
+)
+
+// Field describes one field of a message
+type Field struct {
+	Name string
+	Kind Kind
+	// Since is the protocol version that added the field, fields from
+	// before versioning began are 0
+	Since byte
+}
+
+// Schema describes the fields of a message type
+type Schema struct {
+	// Name is what the message is called in logs and tools
+	Name  string
+	Magic []byte
+	// Fields are the fields in the order they are sent, including the
+	// protocol version field at VersionAt
+	Fields []Field
+	// Required is how many fields every message has, even from senders
+	// from before versioning began
+	Required int
+	// VersionAt is the position of the protocol version field
+	VersionAt int
+	// Signed is set when the last field of the message is a signature of
+	// the others, which stays last as fields are added
+	Signed bool
+}
+
+// Check checks a message has the magic and fields of the schema and that
+// each field can be decoded, and returns the protocol version of its sender
+func (s *Schema) Check(b []byte) (version byte, err error) {
+	var fields [][]byte
+	if fields, err = validate.Split(b, s.Magic); err != nil {
+		return
+	}
+	if s.Signed {
+		if len(fields) < 1 {
+			return 0, fmt.Errorf("%s message has no signature", s.Name)
+		}
+		if err = Signature.Check(fields[len(fields)-1]); err != nil {
+			return 0, fmt.Errorf("%s message signature %v", s.Name, err)
+		}
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < s.Required {
+		return 0, fmt.Errorf("%s message has %d fields, at least %d are"+
+			" needed", s.Name, len(fields), s.Required)
+	}
+	if len(fields) > s.VersionAt {
+		if err = checkVersion(fields[s.VersionAt]); err != nil {
+			return 0, fmt.Errorf("%s message protocol version %v", s.Name,
+				err)
+		}
+		version = fields[s.VersionAt][0]
+	}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if i >= len(fields) {
+			if version > 0 && f.Since <= version {
+				return 0, fmt.Errorf("%s message version %d is missing"+
+					" field %d %s", s.Name, version, i+1, f.Name)
+			}
+			break
+		}
+		if err = f.Kind.Check(fields[i]); err != nil {
+			return 0, fmt.Errorf("%s message field %d %s %v", s.Name, i+1,
+				f.Name, err)
+		}
+	}
+	return
+}
+
+// VersionOf returns the protocol version of a message that passed Check
+func (s *Schema) VersionOf(b []byte) (version byte) {
+	fields, err := validate.Split(b, s.Magic)
+	if err != nil {
+		return
+	}
+	if s.Signed && len(fields) > 0 {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) > s.VersionAt && len(fields[s.VersionAt]) == 1 {
+		version = fields[s.VersionAt][0]
+	}
+	return
+}
+
+// Stamp is the protocol version field, added to each message sent at its
+// schema's VersionAt
+type Stamp struct {
+	Version byte
+}
+
+// NewStamp returns the protocol version field of this kopach
+func NewStamp() *Stamp {
+	return &Stamp{Version: Version}
+}
+
+func (s *Stamp) Decode(b []byte) (out []byte) {
+	if len(b) >= 1 {
+		s.Version = b[0]
+		out = b[1:]
+	}
+	return
+}
+
+func (s *Stamp) Encode() []byte {
+	return []byte{s.Version}
+}
+
+// checkVersion checks the protocol version field is one byte and not 0,
+// which is reserved for messages without it
+func checkVersion(field []byte) error {
+	if len(field) != 1 {
+		return fmt.Errorf("is %d bytes, it should be 1", len(field))
+	}
+	if field[0] == 0 {
+		return errors.New("is 0")
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package kopachctrl
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/kopachctrl/identity"
+	"github.com/p9c/kopach/kopachctrl/settings"
+)
+
+// MinerSilentAfter is how long a miner can go without sending anything
+// before it is flagged as silent
+const MinerSilentAfter = time.Second * 10
+
+// Miner is what the controller has learned about a kopach machine from its
+// heartbeats and other messages
+type Miner struct {
+	identity.Identity
+	IPs []string
+	// Workers, Controller and Status are from the latest heartbeat
+	Workers    int32
+	Controller string
+	Status     string
+	// Settings are the settings the miner reports it is running with and
+	// Configured is the time of the settings message it last applied
+	Settings   settings.Settings
+	Configured time.Time
+	// Mix is the thousandths of the hashing time the miner reports giving
+	// to each block version
+	Mix map[int32]int32
+	// Algos is the algorithms the miner reports its workers may mine
+	Algos []string
+	// HashCounts is the number of hashes reported for each block version
+	HashCounts map[int32]uint64
+	// Hashes is the total of the hash counts
+	Hashes uint64
+	// Solutions is the count of solutions received from the miner and
+	// Accepted is how many of them became blocks
+	Solutions int
+	Accepted  int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Silent is set when nothing has been heard from the miner for
+	// MinerSilentAfter
+	Silent bool
+}
+
+// Registry keeps track of every kopach machine that has been heard from,
+// keyed by machine ID
+type Registry struct {
+	mx     sync.Mutex
+	miners map[string]*Miner
+}
+
+func NewRegistry() *Registry {
+	return &Registry{miners: make(map[string]*Miner)}
+}
+
+// minerKey returns the key a miner is kept under. Miners that do not send an
+// identity are keyed by their first address.
+func minerKey(id identity.Identity, ips []*net.IP) string {
+	if id.ID != "" {
+		return id.ID
+	}
+	if len(ips) > 0 {
+		return ips[0].String()
+	}
+	return "unknown"
+}
+
+// addrIPs returns the address a message was received from in the form
+// carried in messages, for keying miners that did not identify themselves
+func addrIPs(id identity.Identity, src net.Addr) (ips []*net.IP) {
+	if id.ID != "" {
+		return
+	}
+	if u, ok := src.(*net.UDPAddr); ok && u != nil {
+		ips = append(ips, &u.IP)
+	}
+	return
+}
+
+// Update finds the record for a miner, creating it if it is new, refreshes
+// its identity, addresses and last seen time and then calls fn with it
+func (r *Registry) Update(id identity.Identity, ips []*net.IP, fn func(m *Miner)) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	key := minerKey(id, ips)
+	m, ok := r.miners[key]
+	if !ok {
+		m = &Miner{HashCounts: make(map[int32]uint64), FirstSeen: time.Now()}
+		r.miners[key] = m
+		log.L.Info("new miner", key, id.Name)
+	}
+	if id.ID != "" {
+		m.Identity = id
+	} else if m.ID == "" {
+		m.ID = key
+		m.Name = key
+	}
+	if len(ips) > 0 {
+		m.IPs = m.IPs[:0]
+		for i := range ips {
+			m.IPs = append(m.IPs, ips[i].String())
+		}
+	}
+	m.LastSeen = time.Now()
+	if m.Silent {
+		log.L.Info("miner", key, m.Name, "is back")
+		m.Silent = false
+	}
+	if fn != nil {
+		fn(m)
+	}
+}
+
+// Heartbeat records a heartbeat message from a miner
+func (r *Registry) Heartbeat(hb *heartbeat.Heartbeat) {
+	r.Update(hb.Identity, hb.IPs, func(m *Miner) {
+		m.Workers = hb.Workers
+		m.Controller = hb.Controller
+		m.Status = hb.Status
+		m.Settings = hb.Settings
+		m.Configured = hb.Configured
+		m.Mix = hb.Mix
+		m.Algos = hb.Algos
+	})
+}
+
+// Sweep flags the miners that have gone silent and returns them
+func (r *Registry) Sweep() (silenced []Miner) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for key, m := range r.miners {
+		if !m.Silent && time.Since(m.LastSeen) > MinerSilentAfter {
+			m.Silent = true
+			log.L.Warn("miner", key, m.Name, "has gone silent, last seen",
+				m.LastSeen)
+			silenced = append(silenced, copyMiner(m))
+		}
+	}
+	return
+}
+
+// Get returns the record of the miner with the given ID
+func (r *Registry) Get(id string) (m Miner, ok bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	var mm *Miner
+	if mm, ok = r.miners[id]; ok {
+		m = copyMiner(mm)
+	}
+	return
+}
+
+// List returns a snapshot of every miner seen, sorted by machine ID
+func (r *Registry) List() (out []Miner) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, m := range r.miners {
+		out = append(out, copyMiner(m))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return
+}
+
+func copyMiner(m *Miner) (out Miner) {
+	out = *m
+	out.IPs = append([]string{}, m.IPs...)
+	out.Settings.Algos = append([]string{}, m.Settings.Algos...)
+	out.Algos = append([]string{}, m.Algos...)
+	out.Mix = make(map[int32]int32, len(m.Mix))
+	for v, n := range m.Mix {
+		out.Mix[v] = n
+	}
+	out.HashCounts = make(map[int32]uint64, len(m.HashCounts))
+	for v, n := range m.HashCounts {
+		out.HashCounts[v] = n
+	}
+	return
+}
+
+// Miners returns a snapshot of every miner the controller has heard from
+func (c *Controller) Miners() []Miner {
+	return c.registry.List()
+}
+
+// Registry returns the registry of miners the controller has heard from
+func (c *Controller) Registry() *Registry {
+	return c.registry
+}
@@ -0,0 +1,19 @@
+//go:build gofuzz
+// +build gofuzz
+
+package settings
+
+// Fuzz is the go-fuzz entry point for settings messages, any message Load
+// accepts must decode without panicking and be refused without the key
+func Fuzz(data []byte) int {
+	s, err := Load(data)
+	if err != nil {
+		return 0
+	}
+	_ = s.Struct()
+	_ = s.String()
+	if s.Verify("fuzz") == nil {
+		panic("settings message verified with the wrong key")
+	}
+	return 1
+}
@@ -0,0 +1,304 @@
+// Package settings is a message type sent by a controller to change how the
+// kopach miners on the LAN are working. The settings can be addressed to all
+// miners or only to the machine IDs listed in the message.
+//
+// Every miner knows the miner password the messages are encrypted with, so
+// settings are additionally signed with the control key, which only needs to
+// be given to the controllers and to the miners that should obey them.
+// Miners without a control key ignore these messages.
+package settings
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/p9c/simplebuffer"
+	"github.com/p9c/simplebuffer/Int32"
+	"github.com/p9c/simplebuffer/Time"
+
+	"github.com/p9c/kopach/kopachctrl/protocol"
+	"github.com/p9c/kopach/simplebuffer/String"
+	"github.com/p9c/kopach/simplebuffer/Strings"
+)
+
+var Magic = []byte{'c', 'n', 'f', 'g'}
+
+// Schema describes the fields of a settings message, which is signed by a
+// last field after these
+var Schema = &protocol.Schema{
+	Name:  "settings",
+	Magic: Magic,
+	Fields: []protocol.Field{
+		{Name: "Time", Kind: protocol.Time},
+		{Name: "Targets", Kind: protocol.Strings},
+		{Name: "Set", Kind: protocol.Int32},
+		{Name: "Workers", Kind: protocol.Int32},
+		{Name: "Algos", Kind: protocol.Strings},
+		{Name: "DutyCycle", Kind: protocol.Int32},
+		{Name: "PauseWindow", Kind: protocol.String},
+		{Name: "Protocol", Kind: protocol.Protocol, Since: 1},
+	},
+	Required:  7,
+	VersionAt: 7,
+	Signed:    true,
+}
+
+// MaxAge is how old a settings message can be before it is ignored
+const MaxAge = time.Minute
+
+// These flags mark which of the settings in a message are to be applied,
+// the others are left as they are
+const (
+	SetWorkers int32 = 1 << iota
+	SetAlgos
+	SetDutyCycle
+	SetPauseWindow
+)
+
+// DutyCyclePeriod is the length of one round of working and resting when
+// the duty cycle is below 100%
+const DutyCyclePeriod = time.Second * 30
+
+// PauseWindowFormat is the layout of the start and end times of a pause
+// window, separated by a dash, such as "09:00-17:30"
+const PauseWindowFormat = "15:04"
+
+type Container struct {
+	simplebuffer.Container
+}
+
+// Settings are the parts of the miner configuration a controller can change
+type Settings struct {
+	// Set is the flags of the settings to apply
+	Set int32
+	// Workers is the number of workers to run
+	Workers int32
+	// Algos is the names of the algorithms the workers may mine, all of them
+	// if it is empty
+	Algos []string
+	// DutyCycle is the percentage of the time to spend mining
+	DutyCycle int32
+	// PauseWindow is a daily time of day range in which to stop mining, or
+	// empty for none
+	PauseWindow string
+}
+
+// Message is a decoded settings message
+type Message struct {
+	Time    time.Time
+	Targets []string
+	Settings
+}
+
+// Get returns a settings message for the given machine IDs, or for all
+// machines if there are none, signed with the control key
+func Get(key string, s Settings, targets ...string) Container {
+	srs := simplebuffer.Serializers{
+		Time.New().Put(time.Now()),
+		Strings.New().Put(targets),
+		Int32.New().Put(s.Set),
+		Int32.New().Put(s.Workers),
+		Strings.New().Put(s.Algos),
+		Int32.New().Put(s.DutyCycle),
+		String.New().Put(s.PauseWindow),
+		protocol.NewStamp(),
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(Magic)
+	for i := range srs {
+		mac.Write(srs[i].Encode())
+	}
+	srs = append(srs, String.New().Put(string(mac.Sum(nil))))
+	return Container{*srs.CreateContainer(Magic)}
+}
+
+// LoadContainer takes a message byte slice payload and loads it into a container
+// ready to be decoded
+func LoadContainer(b []byte) (out Container) {
+	out.Data = b
+	return
+}
+
+// Load checks a message has all the fields of a settings message and the
+// signature and that each can be decoded, and loads it into a container
+func Load(b []byte) (out Container, err error) {
+	if _, err = Schema.Check(b); err != nil {
+		return
+	}
+	out.Data = b
+	return
+}
+
+// Verify checks the message was signed with the control key
+func (j *Container) Verify(key string) (err error) {
+	if key == "" {
+		return errors.New("no control key configured")
+	}
+	n := j.Count()
+	if n < 8 {
+		return errors.New("settings message is missing fields")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(Magic)
+	for i := uint16(0); i < n-1; i++ {
+		mac.Write(j.Get(i))
+	}
+	if !hmac.Equal(mac.Sum(nil), String.New().DecodeOne(j.Get(n-1)).Bytes) {
+		return errors.New("settings message signature is not valid")
+	}
+	return
+}
+
+func (j *Container) GetTime() time.Time {
+	return Time.New().DecodeOne(j.Get(0)).Get()
+}
+
+func (j *Container) GetTargets() []string {
+	return Strings.New().DecodeOne(j.Get(1)).Get()
+}
+
+func (j *Container) GetSet() int32 {
+	return Int32.New().DecodeOne(j.Get(2)).Get()
+}
+
+func (j *Container) GetWorkers() int32 {
+	return Int32.New().DecodeOne(j.Get(3)).Get()
+}
+
+func (j *Container) GetAlgos() []string {
+	return Strings.New().DecodeOne(j.Get(4)).Get()
+}
+
+func (j *Container) GetDutyCycle() int32 {
+	return Int32.New().DecodeOne(j.Get(5)).Get()
+}
+
+func (j *Container) GetPauseWindow() string {
+	return String.New().DecodeOne(j.Get(6)).Get()
+}
+
+// IsFor returns true if the message is addressed to the given machine ID
+func (j *Container) IsFor(id string) bool {
+	targets := j.GetTargets()
+	if len(targets) == 0 {
+		return true
+	}
+	for i := range targets {
+		if targets[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *Container) String() (s string) {
+	s += fmt.Sprint("\ntype '"+string(Magic)+"' elements:", j.Count())
+	s += "\n"
+	s += fmt.Sprint("1 Time: ", j.GetTime())
+	s += "\n"
+	s += "2 Targets: " + strings.Join(j.GetTargets(), " ")
+	s += "\n"
+	s += fmt.Sprintf("3 Set: %04b", j.GetSet())
+	s += "\n"
+	s += fmt.Sprint("4 Workers: ", j.GetWorkers())
+	s += "\n"
+	s += "5 Algos: " + strings.Join(j.GetAlgos(), " ")
+	s += "\n"
+	s += fmt.Sprint("6 Duty cycle: ", j.GetDutyCycle())
+	s += "\n"
+	s += "7 Pause window: " + j.GetPauseWindow()
+	s += "\n"
+	return
+}
+
+// Struct deserializes the data all in one go by calling the field deserializing
+// functions into a structure containing the fields.
+func (j *Container) Struct() (out Message) {
+	out = Message{
+		Time:    j.GetTime(),
+		Targets: j.GetTargets(),
+		Settings: Settings{
+			Set:         j.GetSet(),
+			Workers:     j.GetWorkers(),
+			Algos:       j.GetAlgos(),
+			DutyCycle:   j.GetDutyCycle(),
+			PauseWindow: j.GetPauseWindow(),
+		},
+	}
+	return
+}
+
+// Validate checks the settings that are to be applied make sense
+func (s *Settings) Validate() (err error) {
+	if s.Set&SetWorkers != 0 && s.Workers < 0 {
+		return fmt.Errorf("worker count %d is negative", s.Workers)
+	}
+	if s.Set&SetDutyCycle != 0 && (s.DutyCycle < 1 || s.DutyCycle > 100) {
+		return fmt.Errorf("duty cycle %d%% is not between 1 and 100",
+			s.DutyCycle)
+	}
+	if s.Set&SetPauseWindow != 0 && s.PauseWindow != "" {
+		if _, _, err = ParsePauseWindow(s.PauseWindow); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ParsePauseWindow returns the start and end of a pause window as the time
+// since midnight
+func ParsePauseWindow(w string) (start, end time.Duration, err error) {
+	parts := strings.Split(w, "-")
+	if len(parts) != 2 {
+		err = fmt.Errorf("pause window '%s' is not two times separated by"+
+			" a dash", w)
+		return
+	}
+	var t [2]time.Time
+	for i := range parts {
+		if t[i], err = time.Parse(PauseWindowFormat,
+			strings.TrimSpace(parts[i])); err != nil {
+			return
+		}
+	}
+	start = time.Duration(t[0].Hour())*time.Hour +
+		time.Duration(t[0].Minute())*time.Minute
+	end = time.Duration(t[1].Hour())*time.Hour +
+		time.Duration(t[1].Minute())*time.Minute
+	return
+}
+
+// InPauseWindow returns true if the time falls within the pause window.
+// Windows that end before they start run over midnight.
+func InPauseWindow(w string, now time.Time) bool {
+	if w == "" {
+		return false
+	}
+	start, end, err := ParsePauseWindow(w)
+	if err != nil {
+		return false
+	}
+	y, m, d := now.Date()
+	since := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	if start <= end {
+		return since >= start && since < end
+	}
+	return since >= start || since < end
+}
+
+// ShouldRest returns true if the duty cycle or the pause window say miners
+// should not be working at the given time
+func (s *Settings) ShouldRest(now time.Time) bool {
+	if InPauseWindow(s.PauseWindow, now) {
+		return true
+	}
+	if s.DutyCycle > 0 && s.DutyCycle < 100 {
+		phase := now.UnixNano() % int64(DutyCyclePeriod)
+		return phase >= int64(DutyCyclePeriod)/100*int64(s.DutyCycle)
+	}
+	return false
+}
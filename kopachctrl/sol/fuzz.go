@@ -0,0 +1,17 @@
+//go:build gofuzz
+// +build gofuzz
+
+package sol
+
+// Fuzz is the go-fuzz entry point for solution messages, any message Load
+// accepts must decode without panicking
+func Fuzz(data []byte) int {
+	s, err := Load(data)
+	if err != nil {
+		return 0
+	}
+	_ = s.GetSenderPort()
+	_ = s.GetMsgBlock()
+	_ = s.GetIdentity()
+	return 1
+}
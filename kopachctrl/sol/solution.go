@@ -1,16 +1,38 @@
 package sol
 
 import (
+	"bytes"
+	"fmt"
+
 	"github.com/p9c/wire"
 	"github.com/p9c/simplebuffer"
 	"github.com/p9c/simplebuffer/Block"
 	"github.com/p9c/simplebuffer/Int32"
+
+	"github.com/p9c/kopach/kopachctrl/identity"
+	"github.com/p9c/kopach/kopachctrl/protocol"
 )
 
 
 // SolutionMagic is the marker for packets containing a solution
 var SolutionMagic = []byte{'s', 'o', 'l', 'v'}
 
+// Schema describes the fields of a solution. Workers that report to a
+// kopach process send only the port and block, which the kopach sends on
+// with its identity.
+var Schema = &protocol.Schema{
+	Name:  "solution",
+	Magic: SolutionMagic,
+	Fields: []protocol.Field{
+		{Name: "SenderPort", Kind: protocol.Int32},
+		{Name: "Block", Kind: protocol.Block},
+		{Name: "Identity", Kind: protocol.Identity},
+		{Name: "Protocol", Kind: protocol.Protocol, Since: 1},
+	},
+	Required:  2,
+	VersionAt: 3,
+}
+
 type SolContainer struct {
 	simplebuffer.Container
 }
@@ -21,12 +43,37 @@ func GetSolContainer(port uint32, b *wire.MsgBlock) *SolContainer {
 	return &SolContainer{*srs}
 }
 
+// GetIdentifiedSolContainer returns a solution that also names the machine
+// that found it
+func GetIdentifiedSolContainer(port uint32, b *wire.MsgBlock,
+	id identity.Identity) *SolContainer {
+	srs := simplebuffer.Serializers{Int32.New().Put(int32(port)),
+		Block.New().Put(b), identity.New().Put(id), protocol.NewStamp()}.
+		CreateContainer(SolutionMagic)
+	return &SolContainer{*srs}
+}
+
 func LoadSolContainer(b []byte) (out *SolContainer) {
 	out = &SolContainer{}
 	out.Data = b
 	return
 }
 
+// Load checks a message has the fields of a solution, that each can be
+// decoded and that the block deserializes, and loads it into a container
+func Load(b []byte) (out *SolContainer, err error) {
+	if _, err = Schema.Check(b); err != nil {
+		return
+	}
+	out = LoadSolContainer(b)
+	var mB wire.MsgBlock
+	if err = mB.Deserialize(bytes.NewReader(Block.New().
+		DecodeOne(out.Get(1)).Bytes)); err != nil {
+		return nil, err
+	}
+	return
+}
+
 func (sC *SolContainer) GetMsgBlock() *wire.MsgBlock {
 	// log.L.Traces(sC.Data)
 	buff := sC.Get(1)
@@ -44,3 +91,39 @@ func (sC *SolContainer) GetSenderPort() int32 {
 	got := decoded.Get()
 	return got
 }
+
+// GetIdentity returns the machine that found the solution, or an empty
+// identity if the sender did not say
+func (sC *SolContainer) GetIdentity() (out identity.Identity) {
+	if sC.Count() > 2 {
+		out = identity.New().DecodeOne(sC.Get(2)).Get()
+	}
+	return
+}
+
+func (sC *SolContainer) String() (s string) {
+	s += fmt.Sprint("\ntype '"+string(SolutionMagic)+"' elements:",
+		sC.Count())
+	s += "\n"
+	s += fmt.Sprint("1 Sender port: ", sC.GetSenderPort())
+	s += "\n"
+	h := sC.GetMsgBlock().Header
+	s += "2 Block header:\n"
+	s += fmt.Sprint("  Version: ", h.Version)
+	s += "\n"
+	s += "  Previous block: " + h.PrevBlock.String()
+	s += "\n"
+	s += "  Merkle root: " + h.MerkleRoot.String()
+	s += "\n"
+	s += fmt.Sprint("  Timestamp: ", h.Timestamp)
+	s += "\n"
+	s += fmt.Sprintf("  Bits: %08x", h.Bits)
+	s += "\n"
+	s += fmt.Sprint("  Nonce: ", h.Nonce)
+	s += "\n"
+	if sC.Count() > 2 {
+		s += "3 Identity: " + sC.GetIdentity().String()
+		s += "\n"
+	}
+	return
+}
@@ -0,0 +1,282 @@
+package kopachctrl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/chainhash"
+
+	"github.com/p9c/kopach/kopachctrl/history"
+)
+
+const (
+	// SubscriberBuffer is how many events a subscriber can fall behind by
+	// before events are dropped for it
+	SubscriberBuffer = 64
+	// KeepAliveInterval is how often an idle event stream is sent a comment
+	// so proxies and browsers keep it open
+	KeepAliveInterval = time.Second * 15
+)
+
+// The types of events
+const (
+	// EventJob is a new job sent to the miners
+	EventJob = "job"
+	// EventSolution is a solution received from a miner, with its outcome
+	EventSolution = "solution"
+	// EventBlock is a solution that was accepted as a block
+	EventBlock = "block"
+)
+
+// The outcomes of solutions
+const (
+	Accepted = "accepted"
+	// Stale solutions are for a block that is no longer the tip
+	Stale = "stale"
+	// Rejected solutions break the consensus rules and Orphan ones do not
+	// connect to the chain
+	Rejected = "rejected"
+	Orphan   = "orphan"
+	// Unusable solutions are for a block version there is no coinbase for,
+	// or could not be processed
+	Unusable = "unusable"
+)
+
+// Status is a snapshot of the state of a controller
+type Status struct {
+	// Height is the height of the block the current job is for
+	Height int32 `json:"height"`
+	// Ready is set once the chain is current and Active while jobs are
+	// being sent
+	Ready  bool `json:"ready"`
+	Active bool `json:"active"`
+	// PrevHash is the block the current job builds on
+	PrevHash string `json:"prevHash"`
+	// Hashrate is the hashes per second reported by the miners and
+	// HashCounts the hashes reported for each block version
+	Hashrate   float64          `json:"hashrate"`
+	HashCounts map[int32]uint64 `json:"hashCounts"`
+	// OtherNodes is the addresses of the other nodes advertising on the LAN
+	OtherNodes []string `json:"otherNodes"`
+	// Solutions is the number of solutions received and Accepted how many of
+	// them became blocks
+	Solutions uint64    `json:"solutions"`
+	Accepted  uint64    `json:"accepted"`
+	Miners    []Miner   `json:"miners"`
+	Began     time.Time `json:"began"`
+}
+
+// Event is a job, solution or block of a controller
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Height is the height of the block of the job or solution
+	Height int32 `json:"height"`
+	// PrevHash is the block the job or solution builds on and Hash the hash
+	// of the solution's block
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Algo     string `json:"algo,omitempty"`
+	// Outcome is what became of a solution
+	Outcome string `json:"outcome,omitempty"`
+	// Miner and MinerID are the machine that sent a solution
+	Miner   string `json:"miner,omitempty"`
+	MinerID string `json:"minerID,omitempty"`
+}
+
+// Status returns a snapshot of the state of the controller
+func (c *Controller) Status() (s Status) {
+	s = Status{
+		Height:     int32(c.height.Load()),
+		Ready:      c.Ready.Load(),
+		Active:     c.active.Load(),
+		Hashrate:   c.hashrate.Load(),
+		HashCounts: c.HashCounts(),
+		OtherNodes: []string{},
+		Solutions:  c.solutions.Load(),
+		Accepted:   c.accepted.Load(),
+		Miners:     c.registry.List(),
+		Began:      c.began,
+	}
+	if h, ok := c.prevHash.Load().(*chainhash.Hash); ok && h != nil {
+		s.PrevHash = h.String()
+	}
+	c.nodesMx.Lock()
+	for o := range c.otherNodes {
+		s.OtherNodes = append(s.OtherNodes, o)
+	}
+	c.nodesMx.Unlock()
+	sort.Strings(s.OtherNodes)
+	if s.Miners == nil {
+		s.Miners = []Miner{}
+	}
+	return
+}
+
+// Subscribe returns a channel receiving the jobs, solutions and blocks of
+// the controller and a function to stop receiving them. Events are dropped
+// for a subscriber that falls behind, and the channel is closed when the
+// controller stops.
+func (c *Controller) Subscribe() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, SubscriberBuffer)
+	c.subMx.Lock()
+	defer c.subMx.Unlock()
+	if c.subs == nil {
+		close(ch)
+		return ch, func() {}
+	}
+	n := c.nextSub
+	c.nextSub++
+	c.subs[n] = ch
+	return ch, func() {
+		c.subMx.Lock()
+		defer c.subMx.Unlock()
+		if sc, ok := c.subs[n]; ok {
+			delete(c.subs, n)
+			close(sc)
+		}
+	}
+}
+
+// publish passes an event on to the subscribers
+func (c *Controller) publish(e Event) {
+	e.Time = time.Now()
+	c.subMx.Lock()
+	defer c.subMx.Unlock()
+	for _, ch := range c.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// closeSubscribers closes the channels of all the subscribers and refuses
+// new ones
+func (c *Controller) closeSubscribers() {
+	c.subMx.Lock()
+	defer c.subMx.Unlock()
+	for n, ch := range c.subs {
+		delete(c.subs, n)
+		close(ch)
+	}
+	c.subs = nil
+}
+
+// errNoHistory is returned when the blocks found are not being recorded
+var errNoHistory = errors.New("no block history is kept")
+
+// Blocks returns the blocks found by the miners recorded in the BlocksFile
+func (c *Controller) Blocks() (blocks []history.Block, err error) {
+	if c.history == nil {
+		return nil, errNoHistory
+	}
+	return c.history.Blocks()
+}
+
+// StatusHandler returns the handler of the status API, which serves the
+// Status as JSON at /status, the totals of the blocks found at /blocks, the
+// events as Server-Sent Events at /events and the metrics for Prometheus at
+// /metrics
+func (c *Controller) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.serveStatus)
+	mux.HandleFunc("/blocks", c.serveBlocks)
+	mux.HandleFunc("/events", c.serveEvents)
+	mux.Handle("/metrics", c.MetricsHandler())
+	return mux
+}
+
+func (c *Controller) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c.Status()); err != nil {
+		log.L.Error(err)
+	}
+}
+
+func (c *Controller) serveBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, err := c.Blocks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(history.Summarize(blocks)); err != nil {
+		log.L.Error(err)
+	}
+}
+
+func (c *Controller) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	events, cancel := c.Subscribe()
+	defer cancel()
+	flusher.Flush()
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.L.Error(err)
+				return
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type,
+				b); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// statusServer serves the status API on the StatusListener until the
+// controller stops
+func (c *Controller) statusServer() (err error) {
+	var l net.Listener
+	if l, err = net.Listen("tcp", c.cfg.StatusListener); err != nil {
+		return
+	}
+	srv := &http.Server{Handler: c.StatusHandler()}
+	go func() {
+		<-c.quit
+		if err := srv.Close(); err != nil {
+			log.L.Error(err)
+		}
+	}()
+	log.L.Info("serving controller status on", l.Addr())
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.L.Error(err)
+		}
+	}()
+	return
+}
@@ -1,10 +1,10 @@
 package kopach
 
 import (
-	"context"
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/urfave/cli"
@@ -13,17 +13,39 @@ import (
 	log "github.com/p9c/logi"
 	"github.com/p9c/transport"
 
-	"github.com/p9c/stdconn/worker"
-
 	"github.com/p9c/chainhash"
+	"github.com/p9c/fork"
 	"github.com/p9c/util/interrupt"
 
 	"github.com/p9c/pod/pkg/conte"
 
-	"github.com/p9c/kopach/client"
+	"github.com/p9c/kopach/capture"
+	"github.com/p9c/kopach/config"
 	"github.com/p9c/kopach/kopachctrl"
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+	"github.com/p9c/kopach/kopachctrl/hashrate"
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/kopachctrl/identity"
 	"github.com/p9c/kopach/kopachctrl/job"
+	"github.com/p9c/kopach/kopachctrl/message"
 	"github.com/p9c/kopach/kopachctrl/pause"
+	"github.com/p9c/kopach/kopachctrl/settings"
+	"github.com/p9c/kopach/kopachctrl/sol"
+	"github.com/p9c/kopach/replay"
+	kw "github.com/p9c/kopach/worker"
+	"github.com/p9c/kopach/worker/event"
+)
+
+const (
+	// HashrateInterval is how often the hash counts of all the workers are
+	// gathered into one report for the controller
+	HashrateInterval = time.Second
+	// HeartbeatInterval is how often the machine announces itself to the
+	// controllers
+	HeartbeatInterval = time.Second * 3
+	// WorkerRestartDelay is how long the miner waits before replacing a
+	// worker that died
+	WorkerRestartDelay = time.Second
 )
 
 type HashCount struct {
@@ -32,118 +54,392 @@ type HashCount struct {
 }
 
 type Worker struct {
+	mx            sync.Mutex
 	active        atomic.Bool
-	conn          *transport.Channel
-	ctx           context.Context
+	conn          broadcast.Channel
+	open          broadcast.Opener
 	quit          chan struct{}
-	cx            *conte.Xt
 	sendAddresses []*net.UDPAddr
-	workers       []*client.Client
+	procs         []*workerProc
+	nextWorker    int
 	FirstSender   atomic.String
 	lastSent      atomic.Int64
 	Status        atomic.String
 	HashTick      chan HashCount
 	LastHash      *chainhash.Hash
+	hashMx        sync.Mutex
+	hashCounts    map[int32]int32
+	hashHeight    int32
+	// rates is the hash counts of the last report and solutions the number
+	// of solutions found
+	rates     map[int32]int32
+	solutions atomic.Int64
+	// mixes is the latest algorithm mix reported by each worker
+	mixes      map[int]map[int32]int32
+	identity   identity.Identity
+	controlKey string
+	// settings are the current settings, which controllers can change, and
+	// configured is the time of the last settings message applied
+	settings   settings.Settings
+	configured time.Time
+	// lastJob is the latest job from the current controller and held is
+	// set while the duty cycle or pause window are resting the workers, or
+	// paused is set
+	lastJob *job.Container
+	held    bool
+	paused  bool
+	// rotation is the algorithm rotation of the workers from the kopach
+	// configuration
+	rotation kw.Settings
+	// cfg is the kopach configuration of the machine
+	cfg *config.Config
+	// record is the file messages are recorded to, if one is open
+	record *os.File
+	// network, pass and logLevel are given to the workers
+	network  string
+	pass     string
+	logLevel string
+	// subs receive the events of the workers
+	subMx   sync.Mutex
+	subs    map[int]chan Event
+	nextSub int
+	metrics *workerMetrics
 }
 
 func KopachHandle(cx *conte.Xt) func(c *cli.Context) error {
 	return func(c *cli.Context) (err error) {
 		log.L.Debug("miner controller starting")
-		ctx, cancel := context.WithCancel(context.Background())
-		w := &Worker{
-			ctx:           ctx,
-			cx:            cx,
-			quit:          cx.KillAll,
-			sendAddresses: []*net.UDPAddr{},
-		}
-		w.lastSent.Store(time.Now().UnixNano())
-		w.active.Store(false)
-		log.L.Debug("opening broadcast channel listener")
-		w.conn, err = transport.
-			NewBroadcastChannel("kopachmain", w, *cx.Config.MinerPass,
-				transport.DefaultPort, kopachctrl.MaxDatagramSize, handlers, cx.KillAll)
-		if err != nil {
+		var m *Miner
+		if m, err = NewMiner(Options{
+			DataDir:  *cx.Config.DataDir,
+			Network:  cx.ActiveNet.Name,
+			Pass:     *cx.Config.MinerPass,
+			Threads:  *cx.Config.GenThreads,
+			LogLevel: *cx.Config.LogLevel,
+		}); err != nil {
 			log.L.Error(err)
-			cancel()
 			return
 		}
-		var wks []*worker.Worker
-		// start up the workers
-		log.L.Debug("starting up kopach workers")
-		for i := 0; i < *cx.Config.GenThreads; i++ {
-			log.L.Debug("starting worker", i)
-			cmd := worker.Spawn(os.Args[0], "worker",
-				cx.ActiveNet.Name, *cx.Config.LogLevel)
-			wks = append(wks, cmd)
-			w.workers = append(w.workers, client.New(cmd.StdConn))
+		if err = m.Start(); err != nil {
+			log.L.Error(err)
+			return
 		}
 		interrupt.AddHandler(func() {
-			w.active.Store(false)
 			log.L.Debug("KopachHandle interrupt")
-			for i := range w.workers {
-				if err := wks[i].Stop(); log.L.Check(err) {
-				}
-				if err := wks[i].Kill(); log.L.Check(err) {
-				}
-				log.L.Debug("stopped worker", i)
-			}
+			m.Stop()
 		})
-		for i := range w.workers {
-			log.L.Debug("sending pass to worker", i)
-			err := w.workers[i].SendPass(*cx.Config.MinerPass)
-			if err != nil {
-				log.L.Error(err)
+		log.L.Debug("listening on", kopachctrl.UDP4MulticastAddress)
+		<-cx.KillAll
+		m.Stop()
+		log.L.Info("kopach shutting down")
+		return
+	}
+}
+
+// newWorker sets up the state of the machine from the kopach configuration
+func newWorker(o *Options) (w *Worker, err error) {
+	w = &Worker{
+		quit:          make(chan struct{}),
+		sendAddresses: []*net.UDPAddr{},
+		hashCounts:    make(map[int32]int32),
+		rates:         make(map[int32]int32),
+		mixes:         make(map[int]map[int32]int32),
+		subs:          make(map[int]chan Event),
+		settings: settings.Settings{
+			Workers:   int32(o.Threads),
+			DutyCycle: 100,
+		},
+		network:  o.Network,
+		pass:     o.Pass,
+		logLevel: o.LogLevel,
+		open:     o.Transport,
+	}
+	w.metrics = newWorkerMetrics(w)
+	if w.open == nil {
+		w.open = broadcast.Multicast(transport.DefaultPort,
+			kopachctrl.MaxDatagramSize)
+	}
+	var cfg *config.Config
+	if cfg, err = config.Load(o.DataDir); err != nil {
+		log.L.Error(err)
+		return
+	}
+	if o.InProcess {
+		cfg.InProcess = true
+	}
+	w.identity = identity.Identity{
+		ID:      cfg.ID,
+		Name:    cfg.Name,
+		Version: Version,
+	}
+	w.controlKey = cfg.ControlKey
+	w.cfg = cfg
+	// in-process workers cannot be told the network on the command line
+	// like worker processes are
+	if cfg.InProcess && kw.Testnet(o.Network) {
+		fork.IsTestnet = true
+	}
+	if _, err = kw.ParseRotation(cfg.Rotation); err != nil {
+		log.L.Error(err)
+		return
+	}
+	w.rotation.Rotation = cfg.Rotation
+	w.rotation.Weights = cfg.AlgoWeights
+	if w.rotation.Slice, err = cfg.Slice(); err != nil {
+		log.L.Error(err)
+		return
+	}
+	recorder := o.Recorder
+	if recorder == nil && cfg.Record != "" {
+		if w.record, err = os.OpenFile(cfg.Record, os.O_CREATE|os.O_WRONLY|
+			os.O_APPEND, 0600); err != nil {
+			log.L.Error(err)
+			return
+		}
+		recorder = capture.NewWriter(w.record)
+		log.L.Info("recording messages to", cfg.Record)
+	}
+	if recorder != nil {
+		w.open = replay.Record(w.open, recorder)
+	}
+	log.L.Info("kopach machine", w.identity.Name, w.identity.ID)
+	log.L.Info("mining algorithms", cfg.EffectiveAlgos(nil))
+	w.lastSent.Store(time.Now().UnixNano())
+	w.active.Store(false)
+	w.Status.Store(heartbeat.Waiting)
+	return
+}
+
+// start opens the broadcast channel and starts the workers and the
+// goroutines that look after them
+func (w *Worker) start() (err error) {
+	log.L.Debug("opening broadcast channel listener")
+	w.conn, err = w.open("kopachmain", w, w.pass, handlers, w.quit)
+	if err != nil {
+		log.L.Error(err)
+		return
+	}
+	if w.cfg.MetricsListener != "" {
+		if err := w.metricsServer(); err != nil {
+			log.L.Error("could not serve kopach metrics", err)
+		}
+	}
+	// start up the workers
+	log.L.Debug("starting up kopach workers")
+	w.mx.Lock()
+	threads := int(w.settings.Workers)
+	w.mx.Unlock()
+	if err = w.SetThreads(threads); err != nil {
+		log.L.Error(err)
+	}
+	go w.hashrateReporter()
+	go w.heartbeater()
+	go w.restScheduler()
+	go w.controllerWatcher()
+	w.active.Store(true)
+	return nil
+}
+
+// stop shuts down the workers and the broadcast channel
+func (w *Worker) stop() {
+	w.active.Store(false)
+	close(w.quit)
+	w.stopWorkers()
+	if err := w.conn.Close(); err != nil {
+		log.L.Error(err)
+	}
+	if w.record != nil {
+		if err := w.record.Close(); err != nil {
+			log.L.Error(err)
+		}
+	}
+}
+
+// controllerWatcher forgets the current controller when it stops sending
+// jobs, so other controllers are listened to
+func (w *Worker) controllerWatcher() {
+	log.L.Debug("starting controller watcher")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// if the last message sent was 3 seconds ago the server is almost
+			// certainly disconnected or crashed so clear FirstSender
+			since := time.Now().Sub(time.Unix(0, w.lastSent.Load()))
+			wasSending := since > time.Second*3 && w.FirstSender.Load() != ""
+			if wasSending {
+				log.L.Debug("previous current controller has stopped"+
+					" broadcasting", since, w.FirstSender.Load())
+				// when this string is clear other broadcasts will be listened
+				// to
+				w.FirstSender.Store("")
+				w.Status.Store(heartbeat.Waiting)
+				w.mx.Lock()
+				w.lastJob = nil
+				w.mx.Unlock()
+				// pause the workers
+				w.pauseWorkers()
 			}
+		case <-w.quit:
+			return
 		}
-		w.active.Store(true)
-		// controller watcher thread
-		go func() {
-			log.L.Debug("starting controller watcher")
-			ticker := time.NewTicker(time.Second)
-		out:
-			for {
-				select {
-				case <-ticker.C:
-					// if the last message sent was 3 seconds ago the server is almost certainly disconnected or crashed
-					// so clear FirstSender
-					since := time.Now().Sub(time.Unix(0, w.lastSent.Load()))
-					wasSending := since > time.Second*3 && w.FirstSender.Load() != ""
-					if wasSending {
-						log.L.Debug("previous current controller has stopped"+
-							" broadcasting", since, w.FirstSender.Load())
-						// when this string is clear other broadcasts will be listened to
-						w.FirstSender.Store("")
-						// pause the workers
-						for i := range w.workers {
-							log.L.Debug("sending pause to worker", i)
-							err := w.workers[i].Pause()
-							if err != nil {
-								log.L.Error(err)
-							}
-						}
-					}
-				case <-cx.KillAll:
-					break out
+	}
+}
+
+// getIdentity returns the identity of the machine with its current thread
+// count
+func (w *Worker) getIdentity() identity.Identity {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return w.identity
+}
+
+// eventPump collects the events reported by a worker and relays them on to
+// the controller, so the workers themselves need no network access
+func (w *Worker) eventPump(p *workerProc) {
+	for {
+		events, err := p.client.Events()
+		if err != nil {
+			select {
+			case <-w.quit:
+			default:
+				if !p.stopping.Load() {
+					log.L.Debug("worker", p.n, "event stream ended:", err)
+					w.metrics.exits.Inc()
+					go w.restartWorker(p)
 				}
 			}
-		}()
-		log.L.Debug("listening on", kopachctrl.UDP4MulticastAddress)
-		<-cx.KillAll
-		log.L.Info("kopach shutting down")
+			return
+		}
+		for j := range events {
+			w.handleEvent(p.n, &events[j])
+		}
+	}
+}
+
+func (w *Worker) handleEvent(n int, e *event.Event) {
+	w.publish(n, e)
+	switch e.Type {
+	case event.Hashrate:
+		w.hashMx.Lock()
+		w.hashCounts[e.Version] += e.Count
+		w.hashHeight = e.Height
+		w.hashMx.Unlock()
+		w.metrics.hashes.Add(float64(e.Count),
+			fork.GetAlgoName(e.Version, e.Height))
+	case event.Solution:
+		log.L.Debug("worker", n, "found a solution")
+		w.solutions.Inc()
+		s, err := sol.Load(e.Solution)
+		if err != nil {
+			log.L.Error("worker", n, "sent a bad solution", err)
+			return
+		}
+		s = sol.GetIdentifiedSolContainer(uint32(s.GetSenderPort()),
+			s.GetMsgBlock(), w.getIdentity())
+		if err := w.conn.SendMany(sol.SolutionMagic,
+			transport.GetShards(s.Data)); err != nil {
+			log.L.Error(err)
+		} else {
+			w.metrics.solutions.Inc()
+		}
+	case event.Error:
+		log.L.Error("worker", n, e.Text)
+	case event.Mix:
+		w.hashMx.Lock()
+		w.mixes[n] = e.Shares
+		w.hashMx.Unlock()
+	case event.Switch:
+		log.L.Debug("worker", n, "switched job in", e.Latency)
+	case event.State:
+		log.L.Debug("worker", n, "is", e.Text)
+	}
+}
+
+// hashrateReporter sends one report per interval for the whole machine with
+// the hash counts of all of its workers broken down by algorithm
+func (w *Worker) hashrateReporter() {
+	ticker := time.NewTicker(HashrateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.hashMx.Lock()
+			counts, height := w.hashCounts, w.hashHeight
+			w.hashCounts = make(map[int32]int32)
+			w.rates = counts
+			w.hashMx.Unlock()
+			if len(counts) < 1 {
+				break
+			}
+			hr := hashrate.GetAggregate(counts, height, w.getIdentity())
+			if err := w.conn.SendMany(hashrate.HashrateMagic,
+				transport.GetShards(hr.Data)); err != nil {
+				log.L.Error(err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// heartbeater announces the machine to the controllers on the LAN
+func (w *Worker) heartbeater() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.sendHeartbeat()
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+func (w *Worker) sendHeartbeat() {
+	w.mx.Lock()
+	id, s, configured := w.identity, w.settings, w.configured
+	w.mx.Unlock()
+	hb := heartbeat.Get(id, id.Threads, w.FirstSender.Load(),
+		w.Status.Load(), s, configured, w.mix(),
+		w.cfg.EffectiveAlgos(s.Algos))
+	if err := w.conn.SendMany(heartbeat.Magic,
+		transport.GetShards(hb.Data)); err != nil {
+		log.L.Error(err)
+	}
+}
+
+// mix returns the algorithm mix of the machine, the average of the mixes of
+// its workers
+func (w *Worker) mix() (out map[int32]int32) {
+	w.hashMx.Lock()
+	defer w.hashMx.Unlock()
+	out = make(map[int32]int32)
+	if len(w.mixes) < 1 {
 		return
 	}
+	for _, m := range w.mixes {
+		for ver, share := range m {
+			out[ver] += share
+		}
+	}
+	for ver := range out {
+		out[ver] /= int32(len(w.mixes))
+	}
+	return
 }
 
 // these are the handlers for specific message types.
-var handlers = transport.Handlers{
-	string(job.Magic): func(ctx interface{}, src net.Addr, dst string,
-		b []byte) (err error) {
+var handlers = message.Handlers{
+	Job: func(ctx interface{}, msg message.Meta, j job.Container) (err error) {
 		w := ctx.(*Worker)
 		if !w.active.Load() {
 			log.L.Debug("not active")
 			return
 		}
-		j := job.LoadContainer(b)
+		w.metrics.jobs.Inc()
 		ips := j.GetIPs()
 		cP := j.GetControllerListenerPort()
 		addr := net.JoinHostPort(ips[0].String(), fmt.Sprint(cP))
@@ -156,25 +452,36 @@ var handlers = transport.Handlers{
 		}
 		w.FirstSender.Store(addr)
 		w.lastSent.Store(time.Now().UnixNano())
-		for i := range w.workers {
-			err := w.workers[i].NewJob(&j)
+		w.mx.Lock()
+		w.lastJob = &j
+		held := w.held
+		w.mx.Unlock()
+		if held {
+			// the workers get the latest job when they wake
+			return
+		}
+		w.Status.Store(heartbeat.Mining)
+		for _, c := range w.clients() {
+			err := c.NewJob(&j)
 			if err != nil {
 				log.L.Error(err)
 			}
 		}
 		return
 	},
-	string(pause.PauseMagic): func(ctx interface{}, src net.Addr, dst string,
-		b []byte) (err error) {
+	Pause: func(ctx interface{}, msg message.Meta,
+		p *pause.PauseContainer) (err error) {
 		log.L.Debug("received pause")
 		w := ctx.(*Worker)
-		for i := range w.workers {
-			log.L.Debug("sending pause to worker", i)
-			err := w.workers[i].Pause()
-			if err != nil {
-				log.L.Error(err)
-			}
-		}
+		w.metrics.pauses.Inc()
+		w.Status.Store(heartbeat.Paused)
+		// the job is finished, so waking from a rest should wait for the next
+		w.mx.Lock()
+		w.lastJob = nil
+		w.mx.Unlock()
+		w.pauseWorkers()
 		return
 	},
-}
+	Settings: settingsHandler,
+	Rejected: rejected,
+}.Transport()
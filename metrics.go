@@ -0,0 +1,131 @@
+package kopach
+
+import (
+	"net"
+	"net/http"
+	"sort"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/fork"
+
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/kopachctrl/message"
+	"github.com/p9c/kopach/metrics"
+)
+
+// workerMetrics are the metrics of a kopach machine
+type workerMetrics struct {
+	registry  *metrics.Registry
+	jobs      *metrics.Counter
+	pauses    *metrics.Counter
+	solutions *metrics.Counter
+	hashes    *metrics.Counter
+	restarts  *metrics.Counter
+	exits     *metrics.Counter
+	rejected  *metrics.Counter
+}
+
+func newWorkerMetrics(w *Worker) (m *workerMetrics) {
+	m = &workerMetrics{
+		registry: metrics.NewRegistry(),
+		jobs: metrics.NewCounter("kopach_jobs_received_total",
+			"Jobs received from the controllers."),
+		pauses: metrics.NewCounter("kopach_pauses_received_total",
+			"Pause messages received from the controllers."),
+		solutions: metrics.NewCounter("kopach_solutions_total",
+			"Solutions found by the workers and sent to the controller."),
+		hashes: metrics.NewCounter("kopach_hashes_total",
+			"Hashes done by the workers for each algorithm.", "algo"),
+		restarts: metrics.NewCounter("kopach_worker_restarts_total",
+			"Workers started in place of one that stopped without being"+
+				" told to."),
+		exits: metrics.NewCounter("kopach_worker_exits_total",
+			"Workers that stopped without being told to."),
+		rejected: metrics.NewCounter("kopach_decrypt_failures_total",
+			"Messages received that did not decrypt into a valid message"+
+				" of their type.", "type"),
+	}
+	m.registry.Register(m.jobs, m.pauses, m.solutions, m.hashes, m.restarts,
+		m.exits, m.rejected,
+		metrics.NewFunc("kopach_hashrate",
+			"Hashes per second of the workers for each algorithm in the"+
+				" last report.", metrics.GaugeType, w.hashrates),
+		metrics.NewFunc("kopach_workers",
+			"Worker processes or in-process workers running.",
+			metrics.GaugeType, func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(len(w.clients()))}}
+			}),
+		metrics.NewFunc("kopach_paused",
+			"Whether mining is paused by a controller or by the program"+
+				" running the miner.",
+			metrics.GaugeType, func() []metrics.Sample {
+				w.mx.Lock()
+				paused := w.paused
+				w.mx.Unlock()
+				v := 0.0
+				if paused || w.Status.Load() == heartbeat.Paused {
+					v = 1
+				}
+				return []metrics.Sample{{Value: v}}
+			}),
+	)
+	return
+}
+
+// hashrates returns the hashes per second of the last report by algorithm
+func (w *Worker) hashrates() (out []metrics.Sample) {
+	w.hashMx.Lock()
+	rates := make(map[string]float64)
+	for ver, n := range w.rates {
+		rates[fork.GetAlgoName(ver, w.hashHeight)] += float64(n) /
+			HashrateInterval.Seconds()
+	}
+	w.hashMx.Unlock()
+	algos := make([]string, 0, len(rates))
+	for algo := range rates {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	for _, algo := range algos {
+		out = append(out, metrics.Sample{Labels: []string{"algo", algo},
+			Value: rates[algo]})
+	}
+	return
+}
+
+// Metrics returns the metrics of the machine
+func (w *Worker) Metrics() *metrics.Registry {
+	return w.metrics.registry
+}
+
+// metricsServer serves the metrics on the listener in the kopach
+// configuration until the machine stops
+func (w *Worker) metricsServer() (err error) {
+	var l net.Listener
+	if l, err = net.Listen("tcp", w.cfg.MetricsListener); err != nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", w.metrics.registry)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-w.quit
+		if err := srv.Close(); err != nil {
+			log.L.Error(err)
+		}
+	}()
+	log.L.Info("serving kopach metrics on", l.Addr())
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.L.Error(err)
+		}
+	}()
+	return
+}
+
+// rejected counts the messages that do not match their schema
+func rejected(ctx interface{}, msg message.Meta, name string, err error) {
+	w := ctx.(*Worker)
+	w.metrics.rejected.Inc(name)
+}
@@ -0,0 +1,367 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format, so the controllers and miners on a
+// LAN can be scraped without reading their logs.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The types of metrics
+const (
+	CounterType   = "counter"
+	GaugeType     = "gauge"
+	HistogramType = "histogram"
+)
+
+// DefaultBuckets are the upper bounds of the buckets of a histogram of
+// durations in seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25,
+	.5, 1, 2.5, 5, 10}
+
+// Sample is one value of a metric
+type Sample struct {
+	// Suffix is added to the name of the metric, such as _bucket
+	Suffix string
+	// Labels are the names and values of the labels of the sample, in pairs
+	Labels []string
+	Value  float64
+}
+
+// Collector is a metric that can be written out
+type Collector interface {
+	// Describe returns the name, help and type of the metric
+	Describe() (name, help, typ string)
+	// Collect returns the current samples of the metric
+	Collect() []Sample
+}
+
+// series is the values of a metric with labels, keyed by their values
+type series struct {
+	mx     sync.Mutex
+	labels []string
+	values map[string]*value
+}
+
+type value struct {
+	labels []string
+	v      float64
+	// buckets and sum are only used by histograms
+	buckets []uint64
+	sum     float64
+}
+
+// newSeries makes the values of a metric, one with no labels starts at 0
+// so it is written out before anything is counted
+func newSeries(labels []string) (s series) {
+	s = series{labels: labels, values: make(map[string]*value)}
+	if len(labels) < 1 {
+		s.get(nil)
+	}
+	return
+}
+
+// get returns the value for the label values, which must be locked
+func (s *series) get(labelValues []string) *value {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metric has %d labels, %d values were given",
+			len(s.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v, ok := s.values[key]
+	if !ok {
+		v = &value{labels: append([]string{}, labelValues...)}
+		s.values[key] = v
+	}
+	return v
+}
+
+// pairs returns the labels of a value as name and value pairs
+func (s *series) pairs(v *value, extra ...string) (out []string) {
+	for i := range s.labels {
+		out = append(out, s.labels[i], v.labels[i])
+	}
+	return append(out, extra...)
+}
+
+// sorted returns the values in the order of their labels
+func (s *series) sorted() (out []*value) {
+	for _, v := range s.values {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].labels, "\xff") <
+			strings.Join(out[j].labels, "\xff")
+	})
+	return
+}
+
+// Counter is a count that only goes up, for each set of label values
+type Counter struct {
+	name, help string
+	series
+}
+
+// NewCounter creates a counter with the names of its labels
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{name: name, help: help, series: newSeries(labels)}
+}
+
+// Inc adds one to the count for the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds to the count for the label values, negative amounts are ignored
+func (c *Counter) Add(n float64, labelValues ...string) {
+	if n < 0 {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.get(labelValues).v += n
+}
+
+func (c *Counter) Describe() (name, help, typ string) {
+	return c.name, c.help, CounterType
+}
+
+func (c *Counter) Collect() (out []Sample) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for _, v := range c.sorted() {
+		out = append(out, Sample{Labels: c.pairs(v), Value: v.v})
+	}
+	return
+}
+
+// Gauge is a value that goes up and down, for each set of label values
+type Gauge struct {
+	name, help string
+	series
+}
+
+// NewGauge creates a gauge with the names of its labels
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{name: name, help: help, series: newSeries(labels)}
+}
+
+// Set sets the value for the label values
+func (g *Gauge) Set(n float64, labelValues ...string) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.get(labelValues).v = n
+}
+
+func (g *Gauge) Describe() (name, help, typ string) {
+	return g.name, g.help, GaugeType
+}
+
+func (g *Gauge) Collect() (out []Sample) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	for _, v := range g.sorted() {
+		out = append(out, Sample{Labels: g.pairs(v), Value: v.v})
+	}
+	return
+}
+
+// Histogram counts observations into buckets, for each set of label values
+type Histogram struct {
+	name, help string
+	bounds     []float64
+	series
+}
+
+// NewHistogram creates a histogram with the upper bounds of its buckets,
+// DefaultBuckets if there are none, and the names of its labels
+func NewHistogram(name, help string, bounds []float64,
+	labels ...string) *Histogram {
+	if len(bounds) < 1 {
+		bounds = DefaultBuckets
+	}
+	bounds = append([]float64{}, bounds...)
+	sort.Float64s(bounds)
+	return &Histogram{name: name, help: help, bounds: bounds,
+		series: newSeries(labels)}
+}
+
+// Observe adds an observation for the label values
+func (h *Histogram) Observe(n float64, labelValues ...string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	v := h.get(labelValues)
+	if v.buckets == nil {
+		v.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, bound := range h.bounds {
+		if n <= bound {
+			v.buckets[i]++
+		}
+	}
+	v.sum += n
+	v.v++
+}
+
+func (h *Histogram) Describe() (name, help, typ string) {
+	return h.name, h.help, HistogramType
+}
+
+func (h *Histogram) Collect() (out []Sample) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	for _, v := range h.sorted() {
+		for i, bound := range h.bounds {
+			var n uint64
+			if v.buckets != nil {
+				n = v.buckets[i]
+			}
+			out = append(out, Sample{Suffix: "_bucket",
+				Labels: h.pairs(v, "le", formatFloat(bound)),
+				Value:  float64(n)})
+		}
+		out = append(out,
+			Sample{Suffix: "_bucket", Labels: h.pairs(v, "le", "+Inf"),
+				Value: v.v},
+			Sample{Suffix: "_sum", Labels: h.pairs(v), Value: v.sum},
+			Sample{Suffix: "_count", Labels: h.pairs(v), Value: v.v},
+		)
+	}
+	return
+}
+
+// Func is a metric whose samples are gathered by a function when it is
+// collected, for values that are already kept elsewhere
+type Func struct {
+	name, help, typ string
+	fn              func() []Sample
+}
+
+// NewFunc creates a metric of the type collected by fn
+func NewFunc(name, help, typ string, fn func() []Sample) *Func {
+	return &Func{name: name, help: help, typ: typ, fn: fn}
+}
+
+func (f *Func) Describe() (name, help, typ string) {
+	return f.name, f.help, f.typ
+}
+
+func (f *Func) Collect() []Sample {
+	return f.fn()
+}
+
+// Registry is a set of metrics served together
+type Registry struct {
+	mx         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds metrics to the registry
+func (r *Registry) Register(c ...Collector) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.collectors = append(r.collectors, c...)
+}
+
+// WriteTo writes all the metrics in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	r.mx.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.mx.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		name, help, typ := c.Describe()
+		if _, err = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name,
+			escapeHelp(help), name, typ); err != nil {
+			return cw.n, err
+		}
+		for _, s := range c.Collect() {
+			if _, err = fmt.Fprintf(bw, "%s%s%s %s\n", name, s.Suffix,
+				formatLabels(s.Labels), formatFloat(s.Value)); err != nil {
+				return cw.n, err
+			}
+		}
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics to a scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	if _, err := r.WriteTo(w); err != nil {
+		return
+	}
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (n int, err error) {
+	n, err = c.w.Write(b)
+	c.n += int64(n)
+	return
+}
+
+func formatLabels(pairs []string) string {
+	if len(pairs) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeValue(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeValue(s string) string {
+	return valueEscaper.Replace(s)
+}
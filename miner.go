@@ -0,0 +1,249 @@
+package kopach
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/kopach/capture"
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/metrics"
+	"github.com/p9c/kopach/worker/event"
+)
+
+// SubscriberBuffer is how many events a subscriber can fall behind before
+// further events for it are dropped
+const SubscriberBuffer = 64
+
+var errMinerState = errors.New("miner is not running")
+
+// Options are the settings of an embedded Miner
+type Options struct {
+	// DataDir is where the kopach configuration is kept, with the machine
+	// ID, name, rotation and algorithms
+	DataDir string
+	// Network is the name of the network mined, such as "mainnet" or
+	// "testnet"
+	Network string
+	// Pass is the miner password shared with the controllers
+	Pass string
+	// Threads is the number of hashing threads to start with
+	Threads int
+	// LogLevel is passed on to worker processes
+	LogLevel string
+	// InProcess runs the workers in this process whatever the kopach
+	// configuration says. Worker processes are started by running the
+	// program again with "worker" as the first argument, so a program that
+	// does not handle this like pod does must set it.
+	InProcess bool
+	// Transport joins the group of controllers and miners, UDP multicast
+	// if it is nil. It is used by in-process workers too.
+	Transport broadcast.Opener
+	// Recorder is given the jobs, pauses, solutions and hashrate reports
+	// received and sent if it is not nil, in place of the file in the
+	// kopach configuration
+	Recorder *capture.Writer
+}
+
+// Miner is a kopach miner that can be run from another program. It takes
+// jobs from the controllers on the LAN like the kopach command does.
+type Miner struct {
+	w        *Worker
+	mx       sync.Mutex
+	started  bool
+	stopOnce sync.Once
+}
+
+// Stats is a snapshot of the state of a Miner
+type Stats struct {
+	ID     string
+	Name   string
+	Status string
+	// Controller is the address of the controller sending jobs, empty when
+	// waiting for one
+	Controller string
+	// Threads is the number of hashing threads and Workers the number of
+	// worker processes or in-process workers running them
+	Threads int32
+	Workers int
+	Paused  bool
+	Height  int32
+	// Hashrate is hashes per second by block version in the last report
+	// and Mix the thousandths of the time each version has been mined
+	Hashrate  map[int32]int32
+	Mix       map[int32]int32
+	Solutions int64
+	Algos     []string
+}
+
+// Event is a report from one of the workers of a Miner
+type Event struct {
+	Worker int
+	event.Event
+}
+
+// NewMiner loads the kopach configuration and prepares a miner, which does
+// nothing until it is started
+func NewMiner(o Options) (m *Miner, err error) {
+	var w *Worker
+	if w, err = newWorker(&o); err != nil {
+		return
+	}
+	return &Miner{w: w}, nil
+}
+
+// Start listens for controllers and starts the workers
+func (m *Miner) Start() (err error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.started {
+		return errors.New("miner already started")
+	}
+	if err = m.w.start(); err != nil {
+		return
+	}
+	m.started = true
+	return
+}
+
+// Stop shuts down the workers and stops listening, a stopped Miner cannot
+// be started again
+func (m *Miner) Stop() {
+	m.mx.Lock()
+	started := m.started
+	m.mx.Unlock()
+	if !started {
+		return
+	}
+	m.stopOnce.Do(func() {
+		log.L.Debug("stopping miner")
+		m.w.stop()
+		m.w.closeSubscribers()
+	})
+}
+
+// Pause stops the workers until Resume is called, jobs from the controllers
+// are still followed so the workers start on the latest one
+func (m *Miner) Pause() {
+	m.setPaused(true)
+}
+
+// Resume sets the workers working again after Pause
+func (m *Miner) Resume() {
+	m.setPaused(false)
+}
+
+func (m *Miner) setPaused(paused bool) {
+	m.w.mx.Lock()
+	m.w.paused = paused
+	m.w.mx.Unlock()
+	m.w.updateRest(time.Now())
+}
+
+// SetThreads changes the number of hashing threads
+func (m *Miner) SetThreads(n int) (err error) {
+	m.mx.Lock()
+	started := m.started
+	m.mx.Unlock()
+	m.w.mx.Lock()
+	m.w.settings.Workers = int32(n)
+	m.w.mx.Unlock()
+	if !started {
+		return
+	}
+	select {
+	case <-m.w.quit:
+		return errMinerState
+	default:
+	}
+	return m.w.SetThreads(n)
+}
+
+// Metrics returns the metrics of the miner, which kopach serves for
+// Prometheus on the metrics listener of its configuration
+func (m *Miner) Metrics() *metrics.Registry {
+	return m.w.Metrics()
+}
+
+// Stats returns a snapshot of the state of the miner
+func (m *Miner) Stats() (s Stats) {
+	w := m.w
+	w.mx.Lock()
+	id, paused := w.identity, w.paused
+	algos := w.cfg.EffectiveAlgos(w.settings.Algos)
+	s.Workers = len(w.procs)
+	w.mx.Unlock()
+	s.ID, s.Name, s.Threads, s.Paused = id.ID, id.Name, id.Threads, paused
+	s.Status = w.Status.Load()
+	s.Controller = w.FirstSender.Load()
+	s.Solutions = w.solutions.Load()
+	s.Algos = algos
+	s.Mix = w.mix()
+	w.hashMx.Lock()
+	s.Height = w.hashHeight
+	s.Hashrate = make(map[int32]int32, len(w.rates))
+	for ver, n := range w.rates {
+		s.Hashrate[ver] = n
+	}
+	w.hashMx.Unlock()
+	if s.Status == "" {
+		s.Status = heartbeat.Waiting
+	}
+	return
+}
+
+// Subscribe returns a channel receiving the events of the workers, such as
+// solutions, errors and state changes, and a function to stop receiving
+// them. Events are dropped for a subscriber that falls behind, and the
+// channel is closed when the miner stops.
+func (m *Miner) Subscribe() (events <-chan Event, cancel func()) {
+	return m.w.subscribe()
+}
+
+func (w *Worker) subscribe() (events <-chan Event, cancel func()) {
+	c := make(chan Event, SubscriberBuffer)
+	w.subMx.Lock()
+	defer w.subMx.Unlock()
+	if w.subs == nil {
+		close(c)
+		return c, func() {}
+	}
+	n := w.nextSub
+	w.nextSub++
+	w.subs[n] = c
+	return c, func() {
+		w.subMx.Lock()
+		defer w.subMx.Unlock()
+		if sc, ok := w.subs[n]; ok {
+			delete(w.subs, n)
+			close(sc)
+		}
+	}
+}
+
+// publish passes an event from a worker on to the subscribers
+func (w *Worker) publish(n int, e *event.Event) {
+	w.subMx.Lock()
+	defer w.subMx.Unlock()
+	for _, c := range w.subs {
+		select {
+		case c <- Event{Worker: n, Event: *e}:
+		default:
+		}
+	}
+}
+
+// closeSubscribers closes the channels of all the subscribers and refuses
+// new ones
+func (w *Worker) closeSubscribers() {
+	w.subMx.Lock()
+	defer w.subMx.Unlock()
+	for n, c := range w.subs {
+		delete(w.subs, n)
+		close(c)
+	}
+	w.subs = nil
+}
@@ -0,0 +1,270 @@
+// Package replay records the messages a kopach machine exchanges with the
+// controllers and plays the recordings back into kopach, or straight into
+// message handlers, without a controller. A job that made a worker misbehave
+// can then be given to it again as often as is needed to find out why.
+package replay
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/p9c/fec"
+	log "github.com/p9c/logi"
+	"github.com/p9c/transport"
+
+	"github.com/p9c/kopach/capture"
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+	"github.com/p9c/kopach/kopachctrl/hashrate"
+	"github.com/p9c/kopach/kopachctrl/job"
+	"github.com/p9c/kopach/kopachctrl/pause"
+	"github.com/p9c/kopach/kopachctrl/sol"
+)
+
+// Magics are the message types that are recorded, the jobs and pauses of
+// the controllers and the solutions and hashrate reports sent back to them
+var Magics = [][]byte{
+	job.Magic,
+	pause.PauseMagic,
+	sol.SolutionMagic,
+	hashrate.HashrateMagic,
+}
+
+// ErrStopped is returned when a playback is stopped before its end
+var ErrStopped = errors.New("playback stopped")
+
+var errClosed = errors.New("channel is closed")
+
+func recorded(magic string) bool {
+	for _, m := range Magics {
+		if string(m) == magic {
+			return true
+		}
+	}
+	return false
+}
+
+// Record returns an Opener joining the group with open that writes the
+// messages of the types in Magics received and sent by the member to w
+func Record(open broadcast.Opener, w *capture.Writer) broadcast.Opener {
+	return func(creator string, ctx interface{}, key string,
+		handlers transport.Handlers, quit chan struct{}) (c broadcast.Channel,
+		err error) {
+		wrapped := make(transport.Handlers, len(handlers))
+		for magic, handler := range handlers {
+			if !recorded(magic) {
+				wrapped[magic] = handler
+				continue
+			}
+			handler := handler
+			wrapped[magic] = func(ctx interface{}, src net.Addr, dst string,
+				b []byte) error {
+				r := &capture.Record{Time: time.Now(),
+					Data: append([]byte{}, b...)}
+				if src != nil {
+					r.Source = src.String()
+				}
+				if err := w.Write(r); err != nil {
+					log.L.Error(err)
+				}
+				return handler(ctx, src, dst, b)
+			}
+		}
+		if c, err = open(creator, ctx, key, wrapped, quit); err != nil {
+			return
+		}
+		return &recorder{Channel: c, w: w}, nil
+	}
+}
+
+// recorder is a Channel that writes the messages it sends to a capture
+type recorder struct {
+	broadcast.Channel
+	w *capture.Writer
+}
+
+func (r *recorder) SendMany(magic []byte, shards [][]byte) (err error) {
+	if recorded(string(magic)) {
+		write(r.w, shards)
+	}
+	return r.Channel.SendMany(magic, shards)
+}
+
+// write records a message sent as shards
+func write(w *capture.Writer, shards [][]byte) {
+	// the decoder may correct the shards it is given so it gets copies
+	copies := make([][]byte, len(shards))
+	for i := range shards {
+		copies[i] = append([]byte{}, shards[i]...)
+	}
+	data, err := fec.Decode(copies)
+	if err != nil {
+		log.L.Error("could not record message sent", err)
+		return
+	}
+	if err = w.Write(&capture.Record{Time: time.Now(), Sent: true,
+		Data: data}); err != nil {
+		log.L.Error(err)
+	}
+}
+
+// Load reads all the records of a capture
+func Load(r io.Reader) (records []capture.Record, err error) {
+	cr := capture.NewReader(r)
+	for {
+		var rec capture.Record
+		if rec, err = cr.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		records = append(records, rec)
+	}
+}
+
+// Play gives each record to the handler of its magic with ctx, as the
+// transport would, until the end of the records or until quit is closed.
+// The time between messages is the time between their records divided by
+// speed, so 1 plays them as they were recorded, and at 0 they are played
+// without waiting. Records without a handler are skipped, and the errors of
+// handlers are logged. It returns how many messages were played.
+func Play(records []capture.Record, speed float64, ctx interface{},
+	handlers transport.Handlers, quit chan struct{}) (played int, err error) {
+	if len(records) < 1 {
+		return
+	}
+	start, first := time.Now(), records[0].Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for i := range records {
+		r := &records[i]
+		handler, ok := handlers[r.Magic()]
+		if !ok {
+			continue
+		}
+		if speed > 0 {
+			at := start.Add(time.Duration(float64(r.Time.Sub(first)) /
+				speed))
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(at))
+			select {
+			case <-timer.C:
+			case <-quit:
+				return played, ErrStopped
+			}
+		} else {
+			select {
+			case <-quit:
+				return played, ErrStopped
+			default:
+			}
+		}
+		var src net.Addr
+		if r.Source != "" {
+			if addr, err := net.ResolveUDPAddr("udp", r.Source); err == nil {
+				src = addr
+			}
+		}
+		if err := handler(ctx, src, "replay",
+			append([]byte{}, r.Data...)); err != nil {
+			log.L.Error(err)
+		}
+		played++
+	}
+	return
+}
+
+// Options are the settings of a Session
+type Options struct {
+	// Speed is how many times faster than recorded the messages are played,
+	// as for Play
+	Speed float64
+	// Sent is given the messages the members send while the session plays
+	// if it is not nil, so they can be compared with the recording
+	Sent *capture.Writer
+}
+
+// Session plays a recording to every member that joins it with Open, which
+// is an Opener, so kopach can run on a recording instead of the LAN
+type Session struct {
+	records []capture.Record
+	o       Options
+	wg      sync.WaitGroup
+	mx      sync.Mutex
+	played  int
+}
+
+// NewSession creates a session playing the records
+func NewSession(records []capture.Record, o Options) *Session {
+	return &Session{records: records, o: o}
+}
+
+// Open joins the session, starting a playback of the recording to the
+// handlers if there are any
+func (s *Session) Open(creator string, ctx interface{}, key string,
+	handlers transport.Handlers, quit chan struct{}) (broadcast.Channel,
+	error) {
+	m := &member{s: s, closed: make(chan struct{})}
+	go func() {
+		select {
+		case <-quit:
+			_ = m.Close()
+		case <-m.closed:
+		}
+	}()
+	if len(handlers) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			played, err := Play(s.records, s.o.Speed, ctx, handlers, m.closed)
+			if err != nil {
+				log.L.Debug(creator, err)
+			}
+			s.mx.Lock()
+			s.played += played
+			s.mx.Unlock()
+		}()
+	}
+	return m, nil
+}
+
+// Wait waits for the playbacks started by Open to end and returns how many
+// messages they played
+func (s *Session) Wait() (played int) {
+	s.wg.Wait()
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.played
+}
+
+// member is a Channel of a session
+type member struct {
+	s         *Session
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (m *member) SendMany(magic []byte, shards [][]byte) (err error) {
+	select {
+	case <-m.closed:
+		return errClosed
+	default:
+	}
+	if m.s.o.Sent != nil && recorded(string(magic)) {
+		write(m.s.o.Sent, shards)
+	}
+	return
+}
+
+func (m *member) Close() (err error) {
+	m.closeOnce.Do(func() { close(m.closed) })
+	return
+}
@@ -0,0 +1,134 @@
+package kopach
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/kopach/config"
+	"github.com/p9c/kopach/kopachctrl/heartbeat"
+	"github.com/p9c/kopach/kopachctrl/message"
+	"github.com/p9c/kopach/kopachctrl/settings"
+)
+
+var errWorkerSpawn = errors.New("could not start worker process")
+
+// applySettings changes the settings of the machine as sent by a controller
+// and reports back the result with a heartbeat
+func (w *Worker) applySettings(m *settings.Message) (err error) {
+	if err = m.Validate(); err != nil {
+		return
+	}
+	if m.Set&settings.SetAlgos != 0 {
+		if err = config.CheckAlgos(m.Algos); err != nil {
+			return
+		}
+		if len(w.cfg.EffectiveAlgos(m.Algos)) < 1 {
+			return fmt.Errorf("none of the algorithms %v may be mined on"+
+				" this machine", m.Algos)
+		}
+	}
+	w.mx.Lock()
+	s := w.settings
+	if m.Set&settings.SetWorkers != 0 {
+		s.Workers = m.Workers
+	}
+	if m.Set&settings.SetAlgos != 0 {
+		s.Algos = m.Algos
+	}
+	if m.Set&settings.SetDutyCycle != 0 {
+		s.DutyCycle = m.DutyCycle
+	}
+	if m.Set&settings.SetPauseWindow != 0 {
+		s.PauseWindow = m.PauseWindow
+	}
+	w.settings = s
+	w.configured = m.Time
+	w.mx.Unlock()
+	log.L.Info("applying settings from controller: workers", s.Workers,
+		"algos", s.Algos, "duty cycle", s.DutyCycle, "pause window",
+		s.PauseWindow)
+	if m.Set&settings.SetAlgos != 0 {
+		log.L.Info("mining algorithms", w.cfg.EffectiveAlgos(s.Algos))
+		w.configureWorkers(s)
+	}
+	if err = w.SetThreads(int(s.Workers)); err != nil {
+		log.L.Error(err)
+	}
+	w.updateRest(time.Now())
+	w.sendHeartbeat()
+	return
+}
+
+// updateRest pauses the workers when the duty cycle or pause window call for
+// a rest, or the miner is paused, and sets them working again afterwards
+func (w *Worker) updateRest(now time.Time) {
+	w.mx.Lock()
+	rest := w.settings.ShouldRest(now) || w.paused
+	changed := rest != w.held
+	w.held = rest
+	w.mx.Unlock()
+	if !changed {
+		return
+	}
+	if rest {
+		log.L.Debug("resting workers")
+		w.Status.Store(heartbeat.Held)
+		w.pauseWorkers()
+	} else {
+		log.L.Debug("waking workers")
+		if w.FirstSender.Load() == "" {
+			w.Status.Store(heartbeat.Waiting)
+			return
+		}
+		w.Status.Store(heartbeat.Mining)
+		w.resumeWorkers()
+	}
+}
+
+// restScheduler applies the duty cycle and pause window
+func (w *Worker) restScheduler() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			w.updateRest(now)
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// settingsHandler receives settings sent by controllers
+func settingsHandler(ctx interface{}, msg message.Meta,
+	c settings.Container) (err error) {
+	w := ctx.(*Worker)
+	if w.controlKey == "" {
+		log.L.Trace("ignoring settings, no control key configured")
+		return
+	}
+	if err = c.Verify(w.controlKey); err != nil {
+		log.L.Warn("rejecting settings from", msg.Src, err)
+		return nil
+	}
+	m := c.Struct()
+	if !c.IsFor(w.getIdentity().ID) {
+		return
+	}
+	w.mx.Lock()
+	stale := !m.Time.After(w.configured) ||
+		time.Since(m.Time) > settings.MaxAge
+	w.mx.Unlock()
+	if stale {
+		log.L.Debug("ignoring old or repeated settings from", msg.Src)
+		return
+	}
+	if err = w.applySettings(&m); err != nil {
+		log.L.Warn("could not apply settings from", msg.Src, err)
+		return nil
+	}
+	return
+}
@@ -0,0 +1,290 @@
+// Package sim mines a short chain offline to check the whole path from a job
+// to an accepted block. A controller, an in-memory chain at the minimum
+// difficulty and in-process miners talk over an in-memory hub, so it needs
+// no node or network and faults can be injected into the messages.
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/chaincfg/netparams"
+	"github.com/p9c/chainhash"
+	"github.com/p9c/fork"
+	"github.com/p9c/util"
+
+	blockchain "github.com/p9c/chain"
+
+	"github.com/p9c/kopach"
+	"github.com/p9c/kopach/config"
+	"github.com/p9c/kopach/kopachctrl"
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+	"github.com/p9c/kopach/kopachctrl/memnode"
+)
+
+const (
+	// DefaultBlocks is how many blocks are mined
+	DefaultBlocks = 5
+	// DefaultTimeout is how long the blocks may take
+	DefaultTimeout = time.Minute * 2
+	// pass is the miner password of the simulated LAN
+	pass = "simulation"
+)
+
+// errStopped is returned when the simulation is stopped before it finished
+var errStopped = errors.New("simulation stopped")
+
+type Options struct {
+	// Blocks is how many blocks to mine
+	Blocks int
+	// Miners is how many kopach machines mine and Threads how many hashing
+	// threads each runs
+	Miners  int
+	Threads int
+	// Algos is the names of the algorithms the miners may mine, all of them
+	// if it is empty
+	Algos []string
+	// Faults are injected into every message between the controller and
+	// the miners
+	Faults broadcast.Faults
+	// Seed makes the faults repeatable
+	Seed int64
+	// Timeout is how long the blocks may take to mine
+	Timeout time.Duration
+}
+
+// Block is a block mined in the simulation
+type Block struct {
+	Height  int32
+	Hash    chainhash.Hash
+	Version int32
+	Algo    string
+	// Coinbase is the value paid by the coinbase and Subsidy the value it
+	// should be for the version at the height
+	Coinbase int64
+	Subsidy  int64
+	// Elapsed is the time since the previous block
+	Elapsed time.Duration
+}
+
+// Result is the outcome of a simulation
+type Result struct {
+	Blocks  []Block
+	Elapsed time.Duration
+	// Solutions is the number of solutions found by the miners, more than
+	// the blocks when miners solve the same job
+	Solutions int64
+}
+
+// Params returns the parameters of the simulated chain, the regression test
+// network, which has the lowest proof of work limit
+func Params() *netparams.Params {
+	return &netparams.RegressionTestParams
+}
+
+// Run mines the blocks and checks that each one connects to the one before
+// it, has a valid proof of work and pays the subsidy of its version. The
+// simulation runs on the testnet fork schedule, on which the latest hard
+// fork is active from the first block, and fork.IsTestnet is restored when
+// it ends.
+func Run(o Options, quit chan struct{}) (r *Result, err error) {
+	if o.Blocks < 1 {
+		o.Blocks = DefaultBlocks
+	}
+	if o.Miners < 1 {
+		o.Miners = 1
+	}
+	if o.Threads < 1 {
+		o.Threads = 1
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	wasTestnet := fork.IsTestnet
+	fork.IsTestnet = true
+	defer func() { fork.IsTestnet = wasTestnet }()
+	params := Params()
+	// the controller is reached over the hub, so it needs no interface
+	loopback := net.IPv4(127, 0, 0, 1)
+	hub := broadcast.NewHub(o.Seed)
+	hub.SetFaults(o.Faults)
+	node := memnode.New(params)
+	// note when each block arrives, which the chain does not record
+	var timesMx sync.Mutex
+	times := map[int32]time.Time{0: time.Now()}
+	connected := make(chan struct{}, 1)
+	node.Subscribe(func(n *blockchain.Notification) {
+		if n.Type != blockchain.NTBlockConnected {
+			return
+		}
+		if b, ok := n.Data.(*util.Block); ok {
+			timesMx.Lock()
+			times[b.Height()] = time.Now()
+			timesMx.Unlock()
+		}
+		select {
+		case connected <- struct{}{}:
+		default:
+		}
+	})
+	ctrl := kopachctrl.New(kopachctrl.Config{
+		Params:      params,
+		Pass:        pass,
+		P2PListener: "127.0.0.1:11047",
+		RPCListener: "127.0.0.1:11048",
+		Controller:  "127.0.0.1:11049",
+		IPs:         []*net.IP{&loopback},
+		Transport:   hub.Open,
+	}, node.Backend())
+	go func() {
+		if err := ctrl.Run(); err != nil {
+			log.L.Error(err)
+		}
+	}()
+	defer ctrl.Stop()
+	var miners []*kopach.Miner
+	defer func() {
+		for _, m := range miners {
+			m.Stop()
+		}
+	}()
+	for i := 0; i < o.Miners; i++ {
+		var m *kopach.Miner
+		if m, err = newMiner(i, o.Threads, o.Algos, hub); err != nil {
+			return
+		}
+		miners = append(miners, m)
+	}
+	start := time.Now()
+	timeout := time.After(o.Timeout)
+	for node.Height() < int32(o.Blocks) {
+		select {
+		case <-connected:
+		case <-timeout:
+			return nil, fmt.Errorf("mined %d of %d blocks in %v",
+				node.Height(), o.Blocks, o.Timeout)
+		case <-quit:
+			return nil, errStopped
+		}
+	}
+	r = &Result{Elapsed: time.Since(start)}
+	for _, m := range miners {
+		r.Solutions += m.Stats().Solutions
+	}
+	timesMx.Lock()
+	defer timesMx.Unlock()
+	if r.Blocks, err = check(node, params, int32(o.Blocks), times); err != nil {
+		return
+	}
+	return
+}
+
+// newMiner starts a miner with its own configuration in a temporary
+// directory, which is removed once the configuration is loaded
+func newMiner(n, threads int, algos []string,
+	hub *broadcast.Hub) (m *kopach.Miner, err error) {
+	var dir string
+	if dir, err = ioutil.TempDir("", "kopachsim"); err != nil {
+		return
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.L.Error(err)
+		}
+	}()
+	cfg := &config.Config{Name: fmt.Sprint("sim", n), Algos: algos,
+		InProcess: true}
+	if err = cfg.Save(dir); err != nil {
+		return
+	}
+	if m, err = kopach.NewMiner(kopach.Options{
+		DataDir:   dir,
+		Network:   Params().Name,
+		Pass:      pass,
+		Threads:   threads,
+		InProcess: true,
+		Transport: hub.Open,
+	}); err != nil {
+		return
+	}
+	err = m.Start()
+	return
+}
+
+// check checks every block up to the height connects to the one before it,
+// meets its target and pays the subsidy of its version
+func check(node *memnode.Node, params *netparams.Params, height int32,
+	times map[int32]time.Time) (blocks []Block, err error) {
+	var prev *util.Block
+	if prev, err = node.BlockByHeight(0); err != nil {
+		return
+	}
+	for h := int32(1); h <= height; h++ {
+		var b *util.Block
+		if b, err = node.BlockByHeight(h); err != nil {
+			return
+		}
+		hdr := &b.MsgBlock().Header
+		if !hdr.PrevBlock.IsEqual(prev.Hash()) {
+			return nil, fmt.Errorf("block %d does not connect to block %d",
+				h, h-1)
+		}
+		hash := hdr.BlockHashWithAlgos(h)
+		if blockchain.HashToBig(&hash).Cmp(fork.CompactToBig(hdr.Bits)) > 0 {
+			return nil, fmt.Errorf("block %d hash %v is above its target"+
+				" %08x", h, hash, hdr.Bits)
+		}
+		blk := Block{
+			Height:  h,
+			Hash:    *b.Hash(),
+			Version: hdr.Version,
+			Algo:    fork.GetAlgoName(hdr.Version, h),
+			Subsidy: blockchain.CalcBlockSubsidy(h, params, hdr.Version),
+			Elapsed: times[h].Sub(times[h-1]),
+		}
+		for _, out := range b.MsgBlock().Transactions[0].TxOut {
+			blk.Coinbase += out.Value
+		}
+		if blk.Coinbase != blk.Subsidy {
+			return nil, fmt.Errorf("block %d coinbase pays %d, the subsidy"+
+				" of version %d is %d", h, blk.Coinbase, hdr.Version,
+				blk.Subsidy)
+		}
+		blocks = append(blocks, blk)
+		prev = b
+	}
+	return
+}
+
+// Print writes a table of the blocks mined
+func Print(w io.Writer, r *Result) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if _, err = fmt.Fprintln(tw,
+		"height\talgorithm\tversion\tcoinbase\tsubsidy\telapsed\thash\t"); err != nil {
+		return
+	}
+	for i := range r.Blocks {
+		b := &r.Blocks[i]
+		if _, err = fmt.Fprintf(tw, "%d\t%s\t%d\t%v\t%v\t%v\t%v\t\n",
+			b.Height, b.Algo, b.Version, util.Amount(b.Coinbase),
+			util.Amount(b.Subsidy), b.Elapsed.Round(time.Millisecond),
+			b.Hash); err != nil {
+			return
+		}
+	}
+	if err = tw.Flush(); err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(w, "%d blocks from %d solutions in %v\n",
+		len(r.Blocks), r.Solutions, r.Elapsed.Round(time.Millisecond))
+	return
+}
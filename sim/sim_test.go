@@ -0,0 +1,72 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/p9c/fork"
+
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+)
+
+// TestRun mines a short chain with each of the two lowest versions of the
+// latest hard fork, which pay different subsidies, and once more with shards
+// lost, duplicated and delayed on the way, and fails if a block does not pass
+// the checks of Run or was mined with another algorithm
+func TestRun(t *testing.T) {
+	hf := fork.List[len(fork.List)-1]
+	var versions []int32
+	for ver := range hf.AlgoVers {
+		versions = append(versions, ver)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	faulty := broadcast.Faults{
+		Loss:      0.1,
+		Duplicate: 0.1,
+		Delay:     time.Millisecond * 20,
+		Jitter:    time.Millisecond * 10,
+	}
+	tests := []struct {
+		version int32
+		faults  broadcast.Faults
+	}{
+		{version: versions[0]},
+		{version: versions[1]},
+		{version: versions[0], faults: faulty},
+	}
+	for _, tt := range tests {
+		tt := tt
+		algo := hf.AlgoVers[tt.version]
+		name := algo
+		if tt.faults != (broadcast.Faults{}) {
+			name = fmt.Sprint(algo, "-faults")
+		}
+		t.Run(name, func(t *testing.T) {
+			r, err := Run(Options{
+				Blocks:  2,
+				Miners:  2,
+				Algos:   []string{algo},
+				Faults:  tt.faults,
+				Seed:    1,
+				Timeout: time.Minute,
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Blocks) != 2 {
+				t.Fatalf("mined %d blocks, expected 2", len(r.Blocks))
+			}
+			for _, b := range r.Blocks {
+				if b.Version != tt.version || b.Algo != algo {
+					t.Fatalf("block %d is %s version %d, expected %s"+
+						" version %d", b.Height, b.Algo, b.Version, algo,
+						tt.version)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Package String is a Simplebuffer field holding a string of up to 64k bytes
+package String
+
+import (
+	"encoding/binary"
+)
+
+type String struct {
+	Bytes []byte
+}
+
+func New() *String {
+	return &String{}
+}
+
+func (s *String) DecodeOne(b []byte) *String {
+	s.Decode(b)
+	return s
+}
+
+func (s *String) Decode(b []byte) (out []byte) {
+	if len(b) >= 2 {
+		l := int(binary.BigEndian.Uint16(b[:2]))
+		if len(b) >= 2+l {
+			s.Bytes = b[2 : 2+l]
+			if len(b) > 2+l {
+				out = b[2+l:]
+			}
+		}
+	}
+	return
+}
+
+func (s *String) Encode() (out []byte) {
+	out = make([]byte, 2, 2+len(s.Bytes))
+	binary.BigEndian.PutUint16(out, uint16(len(s.Bytes)))
+	return append(out, s.Bytes...)
+}
+
+func (s *String) Get() string {
+	return string(s.Bytes)
+}
+
+func (s *String) Put(str string) *String {
+	if len(str) > 0xffff {
+		str = str[:0xffff]
+	}
+	s.Bytes = []byte(str)
+	return s
+}
@@ -0,0 +1,61 @@
+// Package Strings is a Simplebuffer field holding a list of up to 255
+// strings of up to 64k bytes each
+package Strings
+
+import (
+	"github.com/p9c/kopach/simplebuffer/String"
+)
+
+type Strings struct {
+	Length  byte
+	Strings []String.String
+}
+
+func New() *Strings {
+	return &Strings{}
+}
+
+func (s *Strings) DecodeOne(b []byte) *Strings {
+	s.Decode(b)
+	return s
+}
+
+func (s *Strings) Decode(b []byte) (out []byte) {
+	if len(b) >= 1 {
+		s.Length = b[0]
+		out = b[1:]
+		for count := s.Length; count > 0 && len(out) >= 2; count-- {
+			str := String.New()
+			out = str.Decode(out)
+			s.Strings = append(s.Strings, *str)
+		}
+	}
+	return
+}
+
+func (s *Strings) Encode() (out []byte) {
+	out = []byte{s.Length}
+	for i := range s.Strings {
+		out = append(out, s.Strings[i].Encode()...)
+	}
+	return
+}
+
+func (s *Strings) Get() (out []string) {
+	for i := range s.Strings {
+		out = append(out, s.Strings[i].Get())
+	}
+	return
+}
+
+func (s *Strings) Put(in []string) *Strings {
+	if len(in) > 255 {
+		in = in[:255]
+	}
+	s.Length = byte(len(in))
+	s.Strings = make([]String.String, len(in))
+	for i := range in {
+		s.Strings[i].Put(in[i])
+	}
+	return s
+}
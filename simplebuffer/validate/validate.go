@@ -0,0 +1,192 @@
+// Package validate checks Simplebuffer containers received from the network
+// before their fields are read. The container and field decoders trust the
+// offsets and lengths in the data, so a truncated message, or one made up by
+// anyone who knows the miner password, can make them panic or return
+// nonsense.
+//
+// Each of the fields that are checked must decode to exactly its length.
+package validate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// headerSize is the magic, the total size and the field count
+const headerSize = 10
+
+var (
+	errShortHeader = errors.New("message is shorter than its header")
+	errEmptyIPs    = errors.New("address list is empty")
+)
+
+// Check checks one field can be decoded
+type Check func(field []byte) error
+
+// Split checks the header and offsets of a container and returns its fields
+func Split(b, magic []byte) (fields [][]byte, err error) {
+	if len(b) < headerSize {
+		return nil, errShortHeader
+	}
+	if string(b[:4]) != string(magic) {
+		return nil, fmt.Errorf("magic '%s' is not '%s'", b[:4], magic)
+	}
+	if size := binary.BigEndian.Uint32(b[4:8]); int64(size) != int64(len(b)) {
+		return nil, fmt.Errorf("message is %d bytes, its header says %d",
+			len(b), size)
+	}
+	count := int(binary.BigEndian.Uint16(b[8:10]))
+	start := headerSize + count*4
+	if start > len(b) {
+		return nil, fmt.Errorf("%d field offsets do not fit in %d bytes",
+			count, len(b))
+	}
+	fields = make([][]byte, count)
+	prev := start
+	for i := 0; i < count; i++ {
+		offset := int(binary.BigEndian.Uint32(b[headerSize+i*4:]))
+		if (i == 0 && offset != start) || offset < prev || offset > len(b) {
+			return nil, fmt.Errorf("field %d offset %d is out of order or"+
+				" outside the message", i+1, offset)
+		}
+		if i > 0 {
+			fields[i-1] = b[prev:offset]
+		}
+		prev = offset
+	}
+	if count > 0 {
+		fields[count-1] = b[prev:]
+	} else if start != len(b) {
+		return nil, errors.New("message has data but no fields")
+	}
+	return
+}
+
+// Fixed returns a check that a field is exactly n bytes long
+func Fixed(n int) Check {
+	return func(field []byte) error {
+		if len(field) != n {
+			return fmt.Errorf("is %d bytes, it should be %d", len(field), n)
+		}
+		return nil
+	}
+}
+
+// Checks of the fixed length field types
+var (
+	Uint16 = Fixed(2)
+	Int32  = Fixed(4)
+	Time   = Fixed(8)
+	Hash   = Fixed(32)
+)
+
+// Table returns a check that a field is a count byte followed by that many
+// entries of size bytes, as Bitses, Hashes and hashrate Counts are
+func Table(size int) Check {
+	return func(field []byte) error {
+		if len(field) < 1 {
+			return errors.New("is empty")
+		}
+		if want := 1 + int(field[0])*size; len(field) != want {
+			return fmt.Errorf("is %d bytes, %d entries need %d", len(field),
+				field[0], want)
+		}
+		return nil
+	}
+}
+
+// Checks of the tables of values by block version
+var (
+	Bitses = Table(8)
+	Hashes = Table(36)
+)
+
+// Prefixed checks a field is a four byte length followed by that many bytes,
+// as Block and Transaction are
+func Prefixed(field []byte) error {
+	if len(field) < 4 {
+		return errors.New("is shorter than its length")
+	}
+	if n := binary.BigEndian.Uint32(field[:4]); int64(n) != int64(len(field)-4) {
+		return fmt.Errorf("has %d bytes, its length says %d", len(field)-4, n)
+	}
+	return nil
+}
+
+// IPs checks a field is a list of at least one IPv4 or IPv6 address, as the
+// addresses of a node must be to reach it
+func IPs(field []byte) error {
+	if len(field) > 0 && field[0] == 0 {
+		return errEmptyIPs
+	}
+	return AnyIPs(field)
+}
+
+// AnyIPs checks a field is a list of IPv4 or IPv6 addresses, which may be
+// empty when the sender has no routeable interface
+func AnyIPs(field []byte) error {
+	if len(field) < 1 {
+		return errors.New("is empty")
+	}
+	rest := field[1:]
+	for i := 0; i < int(field[0]); i++ {
+		if len(rest) < 1 {
+			return fmt.Errorf("has %d of %d addresses", i, field[0])
+		}
+		n := int(rest[0])
+		if n != 4 && n != 16 {
+			return fmt.Errorf("address %d is %d bytes", i+1, n)
+		}
+		if len(rest) < 1+n {
+			return fmt.Errorf("address %d is cut short", i+1)
+		}
+		rest = rest[1+n:]
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("has %d bytes after the addresses", len(rest))
+	}
+	return nil
+}
+
+// String checks a field is a two byte length followed by that many bytes
+func String(field []byte) error {
+	rest, err := stringAt(field)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("has %d bytes after the string", len(rest))
+	}
+	return nil
+}
+
+// Strings checks a field is a count byte followed by that many strings
+func Strings(field []byte) (err error) {
+	if len(field) < 1 {
+		return errors.New("is empty")
+	}
+	rest := field[1:]
+	for i := 0; i < int(field[0]); i++ {
+		if rest, err = stringAt(rest); err != nil {
+			return fmt.Errorf("string %d %v", i+1, err)
+		}
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("has %d bytes after the strings", len(rest))
+	}
+	return
+}
+
+// stringAt checks the string at the head of b and returns what follows it
+func stringAt(b []byte) (rest []byte, err error) {
+	if len(b) < 2 {
+		return nil, errors.New("is shorter than its length")
+	}
+	n := int(binary.BigEndian.Uint16(b[:2]))
+	if len(b) < 2+n {
+		return nil, fmt.Errorf("has %d bytes, its length says %d",
+			len(b)-2, n)
+	}
+	return b[2+n:], nil
+}
@@ -0,0 +1,4 @@
+package kopach
+
+// Version is the version of kopach reported to controllers
+const Version = "0.1.0"
@@ -0,0 +1,346 @@
+package worker
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/VividCortex/ewma"
+	"go.uber.org/atomic"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/fork"
+
+	blockchain "github.com/p9c/chain"
+)
+
+// Rotation is the way a Counter shares work between the algorithms
+type Rotation byte
+
+const (
+	// RotateRounds gives every algorithm the same number of hashes in turn
+	RotateRounds Rotation = iota
+	// RotateTime gives every algorithm the same time in turn, scaled by its
+	// weight
+	RotateTime
+	// RotateDifficulty gives every algorithm time in proportion to the
+	// solutions per second expected from its measured speed and its target
+	RotateDifficulty
+)
+
+const (
+	// DefaultSlice is the time given to each algorithm when rotating by time
+	DefaultSlice = time.Second
+	// MinShare is the least share of the time every algorithm gets when
+	// rotating by difficulty, so that its speed keeps being measured
+	MinShare = 0.02
+)
+
+var rotationNames = map[Rotation]string{
+	RotateRounds:     "rounds",
+	RotateTime:       "time",
+	RotateDifficulty: "difficulty",
+}
+
+func (r Rotation) String() string {
+	return rotationNames[r]
+}
+
+// ParseRotation returns the rotation with the given name, an empty name is
+// the default rotation by rounds
+func ParseRotation(name string) (r Rotation, err error) {
+	if name == "" {
+		return RotateRounds, nil
+	}
+	for r = range rotationNames {
+		if rotationNames[r] == name {
+			return
+		}
+	}
+	err = fmt.Errorf("unknown algorithm rotation '%s'", name)
+	return
+}
+
+type Counter struct {
+	rpa           int32
+	C             atomic.Int32
+	Algos         atomic.Value // []int32
+	RoundsPerAlgo atomic.Int32
+	mx            sync.Mutex
+	rotation      Rotation
+	slice         time.Duration
+	weights       map[int32]float64
+	targets       map[int32]*big.Float
+	// cur is the index of the algorithm of the current time slice, which
+	// started at sliceStart and lasts sliceLen
+	cur        int
+	sliceStart time.Time
+	sliceLen   time.Duration
+	// ver is the version last returned, start is when it was first returned
+	// and run how many hashes have been done on it since, for measuring
+	// speeds
+	ver    int32
+	start  time.Time
+	run    int64
+	speeds map[int32]ewma.MovingAverage
+	// counts is the hashes done on each version since the last TakeCounts
+	counts map[int32]int32
+}
+
+// NewCounter returns an initialized algorithm rolling counter that ensures
+// each miner does equal amounts of every algorithm
+func NewCounter(roundsPerAlgo int32) (c *Counter) {
+	// these will be populated when work arrives
+	var algos []int32
+	// Start the counter at a random position
+	rand.Seed(time.Now().UnixNano())
+	c = &Counter{
+		slice:  DefaultSlice,
+		speeds: make(map[int32]ewma.MovingAverage),
+		counts: make(map[int32]int32),
+	}
+	c.C.Store(int32(rand.Intn(int(roundsPerAlgo)+1) + 1))
+	c.Algos.Store(algos)
+	c.RoundsPerAlgo.Store(roundsPerAlgo)
+	c.rpa = roundsPerAlgo
+	return
+}
+
+// SetRotation changes the way the counter shares work between algorithms.
+// In time rotation each version gets the slice multiplied by its weight,
+// versions without a weight have a weight of 1.
+func (c *Counter) SetRotation(r Rotation, slice time.Duration,
+	weights map[int32]float64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if slice <= 0 {
+		slice = DefaultSlice
+	}
+	c.rotation, c.slice, c.weights = r, slice, weights
+	c.sliceStart = time.Time{}
+}
+
+// SetTargets stores the targets of the versions in a job for weighting them
+// by difficulty
+func (c *Counter) SetTargets(bitses blockchain.TargetBits) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.targets = make(map[int32]*big.Float, len(bitses))
+	for ver, bits := range bitses {
+		c.targets[ver] = new(big.Float).SetInt(fork.CompactToBig(bits))
+	}
+	c.sliceStart = time.Time{}
+}
+
+// GetAlgoVer returns the next algo version based on the current configuration
+func (c *Counter) GetAlgoVer() (ver int32) {
+	return c.Take(1)
+}
+
+// Take returns the algo version for the next n hashes and moves the
+// rotation on by them. They are counted once they are done, by Done.
+func (c *Counter) Take(n int32) (ver int32) {
+	// the formula below rolls through versions with blocks roundsPerAlgo
+	// long for each algorithm by its index
+	algs := c.Algos.Load().([]int32)
+	// log.L.Debug(algs)
+	if c.RoundsPerAlgo.Load() < 1 {
+		log.L.Debug("RoundsPerAlgo is", c.RoundsPerAlgo.Load(), len(algs))
+		return 0
+	}
+	if len(algs) < 1 {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	now := time.Now()
+	switch c.rotation {
+	case RotateTime, RotateDifficulty:
+		var ended bool
+		if c.cur, ended = c.slot(algs, now); ended {
+			c.sliceStart = time.Time{}
+		}
+		ver = algs[c.cur]
+		if c.sliceStart.IsZero() {
+			c.sliceStart = now
+			c.sliceLen = c.sliceFor(ver, algs)
+		}
+	default:
+		ver = algs[(c.C.Load()/
+			c.RoundsPerAlgo.Load())%
+			int32(len(algs))]
+	}
+	c.C.Add(n)
+	if ver != c.ver || c.start.IsZero() {
+		c.measure(now)
+		c.ver, c.start, c.run = ver, now, 0
+	}
+	return
+}
+
+// Peek returns the algo version of the next hash without moving the
+// rotation on, which only hashing does
+func (c *Counter) Peek() (ver int32) {
+	algs := c.Algos.Load().([]int32)
+	if c.RoundsPerAlgo.Load() < 1 || len(algs) < 1 {
+		return 0
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	switch c.rotation {
+	case RotateTime, RotateDifficulty:
+		cur, _ := c.slot(algs, time.Now())
+		ver = algs[cur]
+	default:
+		ver = algs[(c.C.Load()/
+			c.RoundsPerAlgo.Load())%
+			int32(len(algs))]
+	}
+	return
+}
+
+// slot returns the index of the algorithm of the time slice at a time and
+// whether the current slice has ended by then
+func (c *Counter) slot(algs []int32, now time.Time) (cur int, ended bool) {
+	if cur = c.cur; cur >= len(algs) {
+		cur = 0
+	}
+	if ended = !c.sliceStart.IsZero() &&
+		now.Sub(c.sliceStart) >= c.sliceLen; ended {
+		cur = (cur + 1) % len(algs)
+	}
+	return
+}
+
+// Done counts n hashes done on a version
+func (c *Counter) Done(ver, n int32) {
+	if n < 1 {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if ver == c.ver {
+		c.run += int64(n)
+	}
+	c.counts[ver] += n
+}
+
+// sliceFor returns the time to spend on a version in time rotation
+func (c *Counter) sliceFor(ver int32, algs []int32) (d time.Duration) {
+	d = c.slice
+	if c.rotation == RotateDifficulty {
+		d = time.Duration(float64(d) * float64(len(algs)) *
+			c.shares(algs)[ver])
+	}
+	if w, ok := c.weights[ver]; ok {
+		d = time.Duration(float64(d) * w)
+	}
+	return
+}
+
+// shares returns the share of the time each version gets when rotating by
+// difficulty. The expected solutions per second of a version is its speed
+// divided by its difficulty, which is its speed times its target. Versions
+// with no speed measured yet get an equal share so they can be measured.
+func (c *Counter) shares(algs []int32) (out map[int32]float64) {
+	out = make(map[int32]float64, len(algs))
+	yields := make(map[int32]float64, len(algs))
+	var total float64
+	unmeasured := 0
+	for _, ver := range algs {
+		av, ok := c.speeds[ver]
+		target, tok := c.targets[ver]
+		if !ok || !tok || av.Value() <= 0 {
+			unmeasured++
+			continue
+		}
+		t, _ := target.Float64()
+		yields[ver] = av.Value() * t
+		total += yields[ver]
+	}
+	equal := 1 / float64(len(algs))
+	measured := 1 - equal*float64(unmeasured)
+	var sum float64
+	for _, ver := range algs {
+		share := equal
+		if y, ok := yields[ver]; ok && total > 0 {
+			share = measured * y / total
+		}
+		if share < MinShare {
+			share = MinShare
+		}
+		out[ver] = share
+		sum += share
+	}
+	for ver := range out {
+		out[ver] /= sum
+	}
+	return
+}
+
+// Mix returns the share of the hashing time planned for each version, in
+// thousandths
+func (c *Counter) Mix() (out map[int32]int32) {
+	algs := c.Algos.Load().([]int32)
+	out = make(map[int32]int32, len(algs))
+	if len(algs) < 1 {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	slices := make(map[int32]float64, len(algs))
+	var total float64
+	for _, ver := range algs {
+		if c.rotation == RotateRounds {
+			slices[ver] = 1
+		} else {
+			slices[ver] = float64(c.sliceFor(ver, algs))
+		}
+		total += slices[ver]
+	}
+	for ver := range slices {
+		out[ver] = int32(slices[ver] / total * 1000)
+	}
+	return
+}
+
+// measure adds the speed of the run of hashes on the last version to its
+// moving average
+func (c *Counter) measure(now time.Time) {
+	if c.start.IsZero() || c.run < 1 {
+		return
+	}
+	elapsed := now.Sub(c.start).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	av, ok := c.speeds[c.ver]
+	if !ok {
+		av = ewma.NewMovingAverage(5)
+		c.speeds[c.ver] = av
+	}
+	av.Add(float64(c.run) / elapsed)
+}
+
+// Speeds returns the measured hashes per second for each version
+func (c *Counter) Speeds() (out map[int32]float64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	out = make(map[int32]float64, len(c.speeds))
+	for v, av := range c.speeds {
+		out[v] = av.Value()
+	}
+	return
+}
+
+// TakeCounts returns the hashes done on each version since the last call
+func (c *Counter) TakeCounts() (counts map[int32]int32) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	counts = c.counts
+	c.counts = make(map[int32]int32)
+	return
+}
@@ -0,0 +1,26 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPeek checks peeking at the next version neither moves the rotation on
+// nor disagrees with the version the next hashes are taken on
+func TestPeek(t *testing.T) {
+	for _, r := range []Rotation{RotateRounds, RotateTime} {
+		c := NewCounter(RoundsPerAlgo)
+		c.Algos.Store([]int32{5, 6, 7})
+		c.SetRotation(r, time.Hour, nil)
+		for i := 0; i < 3*RoundsPerAlgo; i++ {
+			before := c.C.Load()
+			ver := c.Peek()
+			if c.C.Load() != before {
+				t.Fatalf("%s rotation moved on by peeking", r)
+			}
+			if taken := c.Take(1); taken != ver {
+				t.Fatalf("%s rotation peeked %d then took %d", r, ver, taken)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// SwitchBound is the longest a batch of hashes is allowed to take, which
+	// bounds how long the worker takes to see a new job or a pause. A single
+	// hash that takes longer than this still finishes before the switch.
+	SwitchBound = time.Millisecond * 50
+)
+
+// command is the latest instruction for a run loop. Only the newest is
+// kept, so a burst of jobs while a loop is busy becomes one switch to the
+// last of them.
+type command struct {
+	// run is true to work on the current job and false to pause
+	run bool
+	// posted is when the command was given, for reporting switch latency
+	posted time.Time
+}
+
+// loop is one of the hashing goroutines of a worker
+type loop struct {
+	n    int
+	mx   sync.Mutex
+	next *command
+	wake chan struct{}
+	quit chan struct{}
+	// stride is the size of the nonce range of each loop
+	stride uint32
+}
+
+func newLoop(n int) *loop {
+	return &loop{
+		n:    n,
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+	}
+}
+
+// post replaces any command the loop has not yet taken and wakes it without
+// blocking
+func (l *loop) post(c *command) {
+	l.mx.Lock()
+	l.next = c
+	l.mx.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+		// the loop already has a wake up waiting and will take the latest
+	}
+}
+
+// take returns the latest command, or nil if there is none
+func (l *loop) take() (c *command) {
+	l.mx.Lock()
+	c, l.next = l.next, nil
+	l.mx.Unlock()
+	return
+}
+
+// post gives a command to every run loop of the worker
+func (w *Worker) post(run bool) {
+	c := &command{run: run, posted: time.Now()}
+	w.loopsMx.Lock()
+	defer w.loopsMx.Unlock()
+	w.working = run
+	for _, l := range w.loops {
+		l.post(c)
+	}
+}
+
+// setLoops starts or stops hashing goroutines until n are running, at least
+// one, and divides the nonces between them
+func (w *Worker) setLoops(n int) {
+	if n < 1 {
+		n = 1
+	}
+	w.loopsMx.Lock()
+	defer w.loopsMx.Unlock()
+	if n == len(w.loops) {
+		return
+	}
+	for len(w.loops) > n {
+		last := w.loops[len(w.loops)-1]
+		close(last.quit)
+		w.loops = w.loops[:len(w.loops)-1]
+	}
+	for len(w.loops) < n {
+		l := newLoop(len(w.loops))
+		w.loops = append(w.loops, l)
+		go w.runLoop(l)
+	}
+	stride := uint32(math.MaxUint32/uint64(n) + 1)
+	if n == 1 {
+		stride = 0
+	}
+	// the loops take up the job again to move to their new nonce ranges
+	c := &command{run: w.working, posted: time.Now()}
+	for _, l := range w.loops {
+		l.mx.Lock()
+		l.stride = stride
+		l.mx.Unlock()
+		l.post(c)
+	}
+}
+
+// nextBatch adjusts the number of hashes in a batch so that a batch takes
+// about SwitchBound
+func nextBatch(batch int32, took time.Duration) int32 {
+	switch {
+	case took > SwitchBound && batch > 1:
+		batch = int32(int64(batch) * int64(SwitchBound) / int64(took))
+		if batch < 1 {
+			batch = 1
+		}
+	case took < SwitchBound/2 && batch < HashBatch:
+		batch *= 2
+		if batch > HashBatch {
+			batch = HashBatch
+		}
+	}
+	return batch
+}
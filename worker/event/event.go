@@ -0,0 +1,78 @@
+// Package event defines the messages a kopach worker sends back up to its
+// parent kopach process over the worker IPC connection. The net/rpc API of
+// the worker only carries triggers from parent to child, so the parent
+// collects these by long polling Worker.Events, which returns as soon as
+// there is something to report.
+package event
+
+import (
+	"time"
+)
+
+// Type identifies what an Event is reporting
+type Type byte
+
+const (
+	// Hashrate is a count of hashes done on a version at a height
+	Hashrate Type = iota
+	// Solution carries an encoded sol.SolContainer ready to be broadcast
+	Solution
+	// Error is a failure inside the worker the parent should know about
+	Error
+	// State is a change of the worker run state
+	State
+	// Mix is the share of time the worker plans for each version
+	Mix
+	// Switch is the time taken to start on a job after it was given
+	Switch
+)
+
+// The states a worker reports in a State event
+const (
+	Running = "running"
+	Paused  = "paused"
+	Stopped = "stopped"
+)
+
+// MaxEvents is the most events returned by one call to Worker.Events
+const MaxEvents = 64
+
+// Event is a single report from a worker. Only the fields relevant to the
+// Type are populated.
+type Event struct {
+	Type Type
+	Time time.Time
+	// Count, Version and Height are set for Hashrate events
+	Count   int32
+	Version int32
+	Height  int32
+	// Solution is the encoded solution container for Solution events
+	Solution []byte
+	// Text is the error message or the state name
+	Text string
+	// Shares is the thousandths of the hashing time given to each version
+	// for Mix events
+	Shares map[int32]int32
+	// Latency is the time a job switch took for Switch events
+	Latency time.Duration
+}
+
+func (t Type) String() (s string) {
+	switch t {
+	case Hashrate:
+		s = "hashrate"
+	case Solution:
+		s = "solution"
+	case Error:
+		s = "error"
+	case State:
+		s = "state"
+	case Mix:
+		s = "mix"
+	case Switch:
+		s = "switch"
+	default:
+		s = "unknown"
+	}
+	return
+}
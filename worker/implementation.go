@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -21,85 +22,85 @@ import (
 
 	"github.com/p9c/chainhash"
 	"github.com/p9c/fork"
-	"github.com/p9c/util"
 	"github.com/p9c/util/interrupt"
 	"github.com/p9c/wire"
 
 	blockchain "github.com/p9c/chain"
 
 	"github.com/p9c/kopach/kopachctrl"
+	"github.com/p9c/kopach/kopachctrl/broadcast"
 	"github.com/p9c/kopach/kopachctrl/hashrate"
 	"github.com/p9c/kopach/kopachctrl/job"
 	"github.com/p9c/kopach/kopachctrl/sol"
+	"github.com/p9c/kopach/worker/event"
 	"github.com/p9c/pod/pkg/sem"
 )
 
-const RoundsPerAlgo = 69
+const (
+	RoundsPerAlgo = 69
+	// EventBufferSize is how many events are held for the parent before
+	// further events are dropped. Solutions are queued apart from these and
+	// are never dropped.
+	EventBufferSize = 256
+)
+
+// Settings are the parameters of a worker set by its parent
+type Settings struct {
+	// Algos is the names of the algorithms the worker may mine, all of the
+	// ones in the job if it is empty
+	Algos []string
+	// Rotation is how work is shared between the algorithms, "rounds" for
+	// an equal number of hashes, "time" for an equal time on each or
+	// "difficulty" for time in proportion to the expected solutions
+	Rotation string
+	// Slice is the time spent on each algorithm when rotating by time
+	Slice time.Duration
+	// Weights scale the time slice of the named algorithms
+	Weights map[string]float64
+	// Threads is the number of hashing goroutines, which share the job and
+	// each take their own range of nonces
+	Threads int32
+}
 
 type Worker struct {
 	mx            sync.Mutex
+	settings      Settings
 	pipeConn      *stdconn.StdConn
 	multicastConn net.Conn
 	unicastConn   net.Conn
-	dispatchConn  *transport.Channel
+	dispatchConn  broadcast.Channel
+	// open joins the group of miners when the pass is given
+	open          broadcast.Opener
 	dispatchReady atomic.Bool
 	ciph          cipher.AEAD
 	Quit          chan struct{}
+	quitOnce      sync.Once
 	run           sem.T
-	block         atomic.Value
 	senderPort    atomic.Uint32
-	msgBlock      atomic.Value // *wire.MsgBlock
-	bitses        atomic.Value
-	hashes        atomic.Value
-	lastMerkle    *chainhash.Hash
-	roller        *Counter
-	startNonce    uint32
-	startChan     chan struct{}
-	stopChan      chan struct{}
-	running       atomic.Bool
+	// job is the *snapshot of the current job, which the run loop copies
+	// when it is woken
+	job        atomic.Value
+	lastMerkle *chainhash.Hash
+	roller     *Counter
+	startNonce uint32
+	// loops are the hashing goroutines and working is whether they were
+	// last told to work or pause
+	loopsMx       sync.Mutex
+	loops         []*loop
+	working       bool
 	hashCount     atomic.Uint64
 	hashSampleBuf *ring.BufferUint64
-}
-
-type Counter struct {
-	rpa           int32
-	C             atomic.Int32
-	Algos         atomic.Value // []int32
-	RoundsPerAlgo atomic.Int32
-}
-
-// NewCounter returns an initialized algorithm rolling counter that ensures
-// each miner does equal amounts of every algorithm
-func NewCounter(roundsPerAlgo int32) (c *Counter) {
-	// these will be populated when work arrives
-	var algos []int32
-	// Start the counter at a random position
-	rand.Seed(time.Now().UnixNano())
-	c = &Counter{}
-	c.C.Store(int32(rand.Intn(int(roundsPerAlgo)+1) + 1))
-	c.Algos.Store(algos)
-	c.RoundsPerAlgo.Store(roundsPerAlgo)
-	c.rpa = roundsPerAlgo
-	return
-}
-
-// GetAlgoVer returns the next algo version based on the current configuration
-func (c *Counter) GetAlgoVer() (ver int32) {
-	// the formula below rolls through versions with blocks roundsPerAlgo
-	// long for each algorithm by its index
-	algs := c.Algos.Load().([]int32)
-	// log.L.Debug(algs)
-	if c.RoundsPerAlgo.Load() < 1 {
-		log.L.Debug("RoundsPerAlgo is", c.RoundsPerAlgo.Load(), len(algs))
-		return 0
-	}
-	if len(algs) > 0 {
-		ver = algs[(c.C.Load()/
-			c.RoundsPerAlgo.Load())%
-			int32(len(algs))]
-		c.C.Add(1)
-	}
-	return
+	events        chan event.Event
+	// solutions are held for the parent apart from the other events so
+	// none are lost when it falls behind, and solutionReady is signalled
+	// when one is added
+	solutionsMx   sync.Mutex
+	solutions     []event.Event
+	solutionReady chan struct{}
+	relay         atomic.Bool
+	// selfTest is the result of checking the hash functions, the worker
+	// takes no jobs if it failed
+	selfTest error
 }
 
 func (w *Worker) hashReport() {
@@ -121,171 +122,169 @@ func (w *Worker) hashReport() {
 	}
 	// log.L.Info("kopach",w.hashSampleBuf.Cursor, w.hashSampleBuf.Buf)
 	log.L.Tracef("average hashrate %.2f", av.Value())
+	log.L.Trace("hashes per second by version", w.roller.Speeds())
+	w.sendMix()
 }
 
 // NewWithConnAndSemaphore is exposed to enable use an actual network
 // connection while retaining the same RPC API to allow a worker to be
 // configured to run on a bare metal system with a different launcher main
 func NewWithConnAndSemaphore(conn *stdconn.StdConn, quit chan struct{}) *Worker {
+	w := newWorker(conn, quit)
+	interrupt.AddHandler(func() {
+		log.L.Debug("worker quitting")
+		w.quit()
+		// w.pipeConn.Close()
+		w.dispatchReady.Store(false)
+	})
+	return w
+}
+
+// newWorker creates a worker and starts its hashing goroutine. It is shut
+// down by closing quit or by Stop, it does not handle the interrupt itself.
+func newWorker(conn *stdconn.StdConn, quit chan struct{}) *Worker {
 	log.L.Debug("creating new worker")
-	msgBlock := wire.MsgBlock{Header: wire.BlockHeader{}}
 	w := &Worker{
 		pipeConn:      conn,
 		Quit:          quit,
 		roller:        NewCounter(RoundsPerAlgo),
-		startChan:     make(chan struct{}),
-		stopChan:      make(chan struct{}),
 		hashSampleBuf: ring.NewBufferUint64(1000),
+		events:        make(chan event.Event, EventBufferSize),
+		solutionReady: make(chan struct{}, 1),
+		open: broadcast.Multicast(transport.DefaultPort,
+			kopachctrl.MaxDatagramSize),
+	}
+	if w.selfTest = SelfTest(fork.IsTestnet); w.selfTest != nil {
+		log.L.Error(w.selfTest)
 	}
-	w.msgBlock.Store(msgBlock)
-	w.block.Store(util.NewBlock(&msgBlock))
 	w.dispatchReady.Store(false)
 	// with this we can report cumulative hash counts as well as using it to
 	// distribute algorithms evenly
 	// tn := time.Now()
 	w.startNonce = uint32(w.roller.C.Load())
-	interrupt.AddHandler(func() {
-		log.L.Debug("worker quitting")
-		close(w.Quit)
-		// w.pipeConn.Close()
-		w.dispatchReady.Store(false)
-	})
-	go func(w *Worker) {
-		log.L.Debug("main work loop starting")
-		sampleTicker := time.NewTicker(time.Second)
-	out:
-		for {
-			// Pause state
-		pausing:
-			for {
-				select {
-				case <-sampleTicker.C:
-					w.hashReport()
-					break pausing
-				case <-w.stopChan:
-					log.L.Trace("received pause signal while paused")
-					// drain stop channel in pause
-					break
-				case <-w.startChan:
-					log.L.Trace("received start signal")
-					break pausing
-				case <-w.Quit:
-					log.L.Trace("quitting")
-					break out
-				}
-				log.L.Trace("worker running")
+	go w.sample()
+	w.setLoops(1)
+	return w
+}
+
+// sample regularly logs the hash rate and reports the algorithm mix
+func (w *Worker) sample() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.hashReport()
+		case <-w.Quit:
+			return
+		}
+	}
+}
+
+// runLoop is a hashing goroutine, switching between jobs and pausing as
+// commands are posted to it. The first loop reports the state of the worker.
+func (w *Worker) runLoop(l *loop) {
+	log.L.Debug("work loop", l.n, "starting")
+	// snap is the copy of the job being worked on and posted is when the
+	// command to work on it was given, until the first batch starts
+	var snap *snapshot
+	var posted time.Time
+	// lastRound is the round of hashes last reported and batch is the
+	// number of hashes between checks for commands
+	var lastRound int32
+	batch := int32(HashBatch)
+out:
+	for {
+		if snap == nil {
+			// paused, so wait for the next command
+			select {
+			case <-l.wake:
+				snap, posted = w.apply(l, snap, posted)
+			case <-l.quit:
+				break out
+			case <-w.Quit:
+				break out
 			}
-			// Run state
-		running:
-			for {
-				select {
-				case <-sampleTicker.C:
-					w.hashReport()
-					break
-				case <-w.startChan:
-					log.L.Trace("received start signal while running")
-					// drain start channel in run mode
-					break
-				case <-w.stopChan:
-					log.L.Trace("received pause signal while running")
-					// w.block.Store(&util.Block{})
-					// w.bitses.Store((blockchain.TargetBits)(nil))
-					// w.hashes.Store((map[int32]*chainhash.Hash)(nil))
-					break running
-				case <-w.Quit:
-					log.L.Trace("worker stopping while running")
-					break out
-				default:
-					if w.block.Load() == nil || w.bitses.Load() == nil || w.hashes.Load() == nil ||
-						!w.dispatchReady.Load() {
-						// log.L.Info("stop was called before we started working")
-					} else {
-						// work
-						nH := w.block.Load().(*util.Block).Height()
-						hv := w.roller.GetAlgoVer()
-						// log.L.Debug(hv)
-						h := w.hashes.Load().(map[int32]*chainhash.Hash)
-						// log.L.Debug("hashes", hv, h)
-						mmb := w.msgBlock.Load().(wire.MsgBlock)
-						mb := &mmb
-						mb.Header.Version = hv
-						if h != nil {
-							mr, ok := h[hv]
-							if !ok {
-								continue
-							}
-							mb.Header.MerkleRoot = *mr
-						} else {
-							continue
-						}
-						b := w.bitses.Load().(blockchain.TargetBits)
-						if bb, ok := b[mb.Header.Version]; ok {
-							mb.Header.Bits = bb
-						} else {
-							continue
-						}
-						var nextAlgo int32
-						if w.roller.C.Load()%w.roller.RoundsPerAlgo.Load() == 0 {
-							select {
-							case <-w.Quit:
-								log.L.Trace("worker stopping on pausing message")
-								break out
-							default:
-							}
-							// log.L.Debug("sending hashcount")
-							// send out broadcast containing worker nonce and algorithm and count of blocks
-							w.hashCount.Store(w.hashCount.Load() + uint64(w.roller.RoundsPerAlgo.Load()))
-							nextAlgo = w.roller.C.Load() + 1
-							hashReport := hashrate.Get(w.roller.RoundsPerAlgo.Load(), nextAlgo, nH)
-							err := w.dispatchConn.SendMany(hashrate.HashrateMagic,
-								transport.GetShards(hashReport.Data))
-							if err != nil {
-								log.L.Error(err)
-							}
-						}
-						hash := mb.Header.BlockHashWithAlgos(nH)
-						bigHash := blockchain.HashToBig(&hash)
-						if bigHash.Cmp(fork.CompactToBig(mb.Header.Bits)) <= 0 {
-							// log.L.Debugc(func() string {
-							//	return fmt.Sprintln(
-							//		"solution found h:", nH,
-							//		hash.String(),
-							//		fork.List[fork.GetCurrent(nH)].
-							//			AlgoVers[mb.Header.Version],
-							//		"total hashes since startup",
-							//		w.roller.C.Load()-int32(w.startNonce),
-							//		fork.IsTestnet,
-							//		mb.Header.Version,
-							//		mb.Header.Bits,
-							//		mb.Header.MerkleRoot.String(),
-							//		hash,
-							//	)
-							// })
-							// log.L.Traces(mb)
-							srs := sol.GetSolContainer(w.senderPort.Load(), mb)
-							err := w.dispatchConn.SendMany(sol.SolutionMagic,
-								transport.GetShards(srs.Data))
-							if err != nil {
-								log.L.Error(err)
-							}
-							log.L.Trace("sent solution")
-							break running
-						}
-						mb.Header.Version = nextAlgo
-						mb.Header.Bits = w.bitses.Load().(blockchain.TargetBits)[mb.Header.Version]
-						mb.Header.Nonce++
-						w.msgBlock.Store(*mb)
-						// if we have completed a cycle report the hashrate on starting new algo
-						// log.L.Debug(w.hashCount.Load(), uint64(w.roller.RoundsPerAlgo), w.roller.C)
-					}
-				}
+			continue
+		}
+		// working, so only check for a command between batches
+		select {
+		case <-l.wake:
+			snap, posted = w.apply(l, snap, posted)
+			continue
+		case <-l.quit:
+			break out
+		case <-w.Quit:
+			break out
+		default:
+		}
+		if !posted.IsZero() {
+			if l.n == 0 {
+				w.sendSwitch(time.Since(posted))
+			}
+			posted = time.Time{}
+		}
+		start := time.Now()
+		hv := w.roller.Take(batch)
+		done, found := snap.hashBatch(hv, batch)
+		w.roller.Done(hv, done)
+		if found {
+			w.sendSolution(snap.msgBlock())
+			log.L.Trace("sent solution")
+			// all the loops wait for the next job
+			w.post(false)
+			continue
+		}
+		batch = nextBatch(batch, time.Since(start))
+		// send out the hash counts for each version after every round of
+		// RoundsPerAlgo hashes
+		if round := w.roller.C.Load() /
+			w.roller.RoundsPerAlgo.Load(); round != lastRound {
+			lastRound = round
+			// a report can span more than one algorithm so it is split by
+			// version
+			var total int32
+			for ver, count := range w.roller.TakeCounts() {
+				w.sendHashrate(count, ver, snap.height)
+				total += count
 			}
+			w.hashCount.Add(uint64(total))
+		}
+	}
+	log.L.Trace("work loop", l.n, "finished")
+	if l.n == 0 {
+		w.emitState(event.Stopped)
+	}
+}
+
+// apply carries out the latest command for a loop, returning the job to work
+// on, nil to pause, and when the command was given
+func (w *Worker) apply(l *loop, snap *snapshot, posted time.Time) (*snapshot,
+	time.Time) {
+	c := l.take()
+	if c == nil {
+		return snap, posted
+	}
+	if !c.run {
+		if snap != nil && l.n == 0 {
 			log.L.Trace("worker pausing")
+			w.emitState(event.Paused)
 		}
-		log.L.Trace("worker finished")
-		// w.Close()
-	}(w)
-	return w
+		return nil, time.Time{}
+	}
+	s, ok := w.job.Load().(*snapshot)
+	if !ok || s == nil {
+		return snap, posted
+	}
+	if snap == nil && l.n == 0 {
+		w.emitState(event.Running)
+	}
+	// the loop works on its own copy of the header, in its own nonce range
+	ss := *s
+	l.mx.Lock()
+	ss.header.Nonce += uint32(l.n) * l.stride
+	l.mx.Unlock()
+	return &ss, c.posted
 }
 
 // New initialises the state for a worker,
@@ -301,47 +300,53 @@ func New(quit chan struct{}) (w *Worker, conn net.Conn) {
 // this makes the miner start mining from pause or pause,
 // prepare the work and restart
 func (w *Worker) NewJob(job *job.Container, reply *bool) (err error) {
+	w.mx.Lock()
+	err = w.selfTest
+	w.mx.Unlock()
+	if err != nil {
+		return
+	}
 	if !w.dispatchReady.Load() { // || !w.running.Load() {
 		*reply = true
 		return
 	}
 	j := job.Struct()
-	w.bitses.Store(j.Bitses)
-	w.hashes.Store(j.Hashes)
 	if j.Hashes[5].IsEqual(w.lastMerkle) {
 		// log.L.Debug("not a new job")
 		*reply = true
 		return
 	}
-	var algos []int32
-	for i := range j.Bitses {
-		// we don't need to know net params if version numbers come with jobs
-		algos = append(algos, i)
-	}
+	newHeight := job.GetNewHeight()
+	algos := w.allowedAlgos(j.Bitses, newHeight)
 	// log.L.Debug(algos)
 	w.lastMerkle = j.Hashes[5]
 	*reply = true
-	// halting current work
-	w.stopChan <- struct{}{}
-	newHeight := job.GetNewHeight()
-
+	if len(j.Bitses) > 0 && len(algos) < 1 {
+		w.post(false)
+		err = errors.New("none of the algorithms in the job are allowed")
+		w.emitError(err)
+		return
+	}
 	if len(algos) > 0 {
 		// if we didn't get them in the job don't update the old
 		w.roller.Algos.Store(algos)
 	}
-	mbb := w.msgBlock.Load().(wire.MsgBlock)
-	mb := &mbb
-	mb.Header.PrevBlock = *job.GetPrevBlockHash()
+	w.roller.SetTargets(j.Bitses)
+	w.setRotation(newHeight)
 	// TODO: ensure worker time sync - ntp? time wrapper with skew adjustment
-	hv := w.roller.GetAlgoVer()
-	mb.Header.Version = hv
+	hv := w.roller.Peek()
+	header := wire.BlockHeader{
+		Version:   hv,
+		PrevBlock: *job.GetPrevBlockHash(),
+		Timestamp: time.Now(),
+	}
 	var ok bool
-	mb.Header.Bits, ok = j.Bitses[mb.Header.Version]
+	header.Bits, ok = j.Bitses[hv]
 	if !ok {
 		return errors.New("bits are empty")
 	}
 	rand.Seed(time.Now().UnixNano())
-	mb.Header.Nonce = rand.Uint32()
+	header.Nonce = rand.Uint32()
 	if j.Hashes == nil {
 		return errors.New("failed to decode merkle roots")
 	} else {
@@ -349,18 +354,111 @@ func (w *Worker) NewJob(job *job.Container, reply *bool) (err error) {
 		if !ok {
 			return errors.New("could not get merkle root from job")
 		}
-		mb.Header.MerkleRoot = *hh
-	}
-	mb.Header.Timestamp = time.Now()
-	// make the work select block start running
-	bb := util.NewBlock(mb)
-	bb.SetHeight(newHeight)
-	w.block.Store(bb)
-	w.msgBlock.Store(*mb)
+		header.MerkleRoot = *hh
+	}
+	w.job.Store(newSnapshot(newHeight, header, j.Bitses, j.Hashes))
 	w.senderPort.Store(uint32(job.GetControllerListenerPort()))
-	// halting current work
-	// w.stopChan <- struct{}{}
-	w.startChan <- struct{}{}
+	// the run loop switches to the job after its current batch
+	w.post(true)
+	return
+}
+
+// allowedAlgos returns the versions in a job the worker may mine
+func (w *Worker) allowedAlgos(bitses blockchain.TargetBits,
+	height int32) (algos []int32) {
+	w.mx.Lock()
+	allowed := w.settings.Algos
+	w.mx.Unlock()
+	for i := range bitses {
+		// we don't need to know net params if version numbers come with jobs
+		if len(allowed) > 0 {
+			name := fork.GetAlgoName(i, height)
+			found := false
+			for j := range allowed {
+				if allowed[j] == name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		algos = append(algos, i)
+	}
+	sort.Slice(algos, func(i, j int) bool { return algos[i] < algos[j] })
+	return
+}
+
+// setRotation applies the rotation settings to the counter, the weights are
+// given by name so they are resolved to versions at the height of the job
+func (w *Worker) setRotation(height int32) {
+	w.mx.Lock()
+	s := w.settings
+	w.mx.Unlock()
+	r, err := ParseRotation(s.Rotation)
+	if err != nil {
+		log.L.Error(err)
+		r = RotateRounds
+	}
+	weights := make(map[int32]float64, len(s.Weights))
+	for _, ver := range w.roller.Algos.Load().([]int32) {
+		if weight, ok := s.Weights[fork.GetAlgoName(ver, height)]; ok {
+			weights[ver] = weight
+		}
+	}
+	w.roller.SetRotation(r, s.Slice, weights)
+}
+
+// Configure changes the settings of the worker, taking effect on the current
+// job if there is one
+func (w *Worker) Configure(s *Settings, reply *bool) (err error) {
+	log.L.Debug("configuring worker", s.Algos, s.Rotation, s.Slice)
+	if _, err = ParseRotation(s.Rotation); err != nil {
+		log.L.Error(err)
+		return
+	}
+	w.mx.Lock()
+	w.settings = *s
+	w.mx.Unlock()
+	*reply = true
+	w.setLoops(int(s.Threads))
+	snap, ok := w.job.Load().(*snapshot)
+	if !ok || snap == nil {
+		return
+	}
+	algos := w.allowedAlgos(snap.bitses, snap.height)
+	if len(algos) < 1 {
+		err = errors.New("none of the algorithms in the job are allowed")
+		w.emitError(err)
+		w.post(false)
+		return
+	}
+	w.roller.Algos.Store(algos)
+	w.setRotation(snap.height)
+	return
+}
+
+// SelfTest checks the hash functions against the known answers of the
+// network the parent mines, testnet or mainnet, and returns the error if
+// they failed. The worker takes no jobs after a failure.
+func (w *Worker) SelfTest(testnet bool, reply *bool) (err error) {
+	err = SelfTest(testnet)
+	w.mx.Lock()
+	w.selfTest = err
+	w.mx.Unlock()
+	*reply = err == nil
+	return
+}
+
+// Resume restarts work on the current job after a pause
+func (w *Worker) Resume(_ int, reply *bool) (err error) {
+	log.L.Debug("resuming from IPC")
+	*reply = true
+	if w.lastMerkle == nil {
+		return
+	}
+	w.post(true)
 	return
 }
 
@@ -368,8 +466,7 @@ func (w *Worker) NewJob(job *job.Container, reply *bool) (err error) {
 // releases its semaphore and the worker is then idle
 func (w *Worker) Pause(_ int, reply *bool) (err error) {
 	log.L.Debug("pausing from IPC")
-	w.running.Store(false)
-	w.stopChan <- struct{}{}
+	w.post(false)
 	*reply = true
 	return
 }
@@ -377,12 +474,18 @@ func (w *Worker) Pause(_ int, reply *bool) (err error) {
 // Stop signals the worker to quit
 func (w *Worker) Stop(_ int, reply *bool) (err error) {
 	log.L.Debug("stopping from IPC")
-	w.stopChan <- struct{}{}
-	defer close(w.Quit)
+	w.post(false)
+	defer w.quit()
 	*reply = true
 	return
 }
 
+// quit closes the quit channel, once only as both the Stop call and the
+// interrupt handler close it
+func (w *Worker) quit() {
+	w.quitOnce.Do(func() { close(w.Quit) })
+}
+
 // SendPass gives the encryption key configured in the kopach controller (
 // pod) configuration to allow workers to dispatch their solutions
 func (w *Worker) SendPass(pass string, reply *bool) (err error) {
@@ -390,14 +493,148 @@ func (w *Worker) SendPass(pass string, reply *bool) (err error) {
 	rand.Seed(time.Now().UnixNano())
 	// sp := fmt.Sprint(rand.Intn(32767) + 1025)
 	// rp := fmt.Sprint(rand.Intn(32767) + 1025)
-	var conn *transport.Channel
-	conn, err = transport.NewBroadcastChannel("kopachworker", w, pass,
-		transport.DefaultPort, kopachctrl.MaxDatagramSize, transport.Handlers{}, w.Quit)
+	var conn broadcast.Channel
+	conn, err = w.open("kopachworker", w, pass, transport.Handlers{}, w.Quit)
 	if err != nil {
 		log.L.Error(err)
+		if w.relay.Load() {
+			// a worker relaying through its parent can still work without
+			// its own connection
+			w.emitError(err)
+			err = nil
+		}
 	}
 	w.dispatchConn = conn
 	w.dispatchReady.Store(true)
 	*reply = true
 	return
 }
+
+// Events blocks until the worker has something to report to its parent and
+// then returns all the solutions found and up to max other queued events.
+// Once a parent is collecting events the worker stops broadcasting hashrate
+// reports and solutions itself and leaves relaying them to the parent.
+func (w *Worker) Events(max int, reply *[]event.Event) (err error) {
+	w.relay.Store(true)
+	if max < 1 || max > event.MaxEvents {
+		max = event.MaxEvents
+	}
+	*reply = w.takeSolutions(*reply)
+	for len(*reply) < 1 {
+		select {
+		case e := <-w.events:
+			*reply = append(*reply, e)
+		case <-w.solutionReady:
+			*reply = w.takeSolutions(*reply)
+		case <-w.Quit:
+			return errors.New("worker is stopping")
+		}
+	}
+	for len(*reply) < max {
+		select {
+		case e := <-w.events:
+			*reply = append(*reply, e)
+		default:
+			return
+		}
+	}
+	return
+}
+
+// takeSolutions appends the solutions waiting for the parent to the events
+// and empties the queue
+func (w *Worker) takeSolutions(events []event.Event) []event.Event {
+	w.solutionsMx.Lock()
+	defer w.solutionsMx.Unlock()
+	events = append(events, w.solutions...)
+	w.solutions = nil
+	return events
+}
+
+// emit queues an event for the parent, dropping it if the parent is not
+// collecting them fast enough
+func (w *Worker) emit(e event.Event) {
+	e.Time = time.Now()
+	select {
+	case w.events <- e:
+	default:
+		log.L.Debug("event buffer full, dropping", e.Type, "event")
+	}
+}
+
+func (w *Worker) emitState(state string) {
+	if !w.relay.Load() {
+		return
+	}
+	w.emit(event.Event{Type: event.State, Text: state})
+}
+
+func (w *Worker) emitError(err error) {
+	if !w.relay.Load() {
+		return
+	}
+	w.emit(event.Event{Type: event.Error, Text: err.Error()})
+}
+
+// sendHashrate reports a count of hashes to the parent, or broadcasts it if
+// this worker is running without one
+func (w *Worker) sendHashrate(count, version, height int32) {
+	if w.relay.Load() {
+		w.emit(event.Event{Type: event.Hashrate, Count: count,
+			Version: version, Height: height})
+		return
+	}
+	hashReport := hashrate.Get(count, version, height)
+	err := w.dispatchConn.SendMany(hashrate.HashrateMagic,
+		transport.GetShards(hashReport.Data))
+	if err != nil {
+		log.L.Error(err)
+	}
+}
+
+// sendMix reports the share of time given to each version to the parent
+func (w *Worker) sendMix() {
+	mix := w.roller.Mix()
+	if len(mix) < 1 {
+		return
+	}
+	if !w.relay.Load() {
+		log.L.Trace("algorithm mix in thousandths", mix)
+		return
+	}
+	w.emit(event.Event{Type: event.Mix, Shares: mix})
+}
+
+// sendSwitch reports how long the worker took to start on a job or resume
+// after it was given
+func (w *Worker) sendSwitch(latency time.Duration) {
+	if !w.relay.Load() {
+		log.L.Trace("switched job in", latency)
+		return
+	}
+	w.emit(event.Event{Type: event.Switch, Latency: latency})
+}
+
+// sendSolution passes a solved block to the parent, or broadcasts it if
+// this worker is running without one
+func (w *Worker) sendSolution(mb *wire.MsgBlock) {
+	srs := sol.GetSolContainer(w.senderPort.Load(), mb)
+	if w.relay.Load() {
+		// a solution is worth far more than the status events, so it waits
+		// for the parent however far behind it is
+		w.solutionsMx.Lock()
+		w.solutions = append(w.solutions, event.Event{Type: event.Solution,
+			Time: time.Now(), Solution: srs.Data})
+		w.solutionsMx.Unlock()
+		select {
+		case w.solutionReady <- struct{}{}:
+		default:
+		}
+		return
+	}
+	err := w.dispatchConn.SendMany(sol.SolutionMagic,
+		transport.GetShards(srs.Data))
+	if err != nil {
+		log.L.Error(err)
+	}
+}
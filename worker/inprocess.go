@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"net"
+	"net/rpc"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/kopach/kopachctrl/broadcast"
+)
+
+// NewInProcess starts a worker in this process, serving its RPC API on one
+// end of an in-memory pipe, and returns the other end for a client. The
+// worker joins the group of miners with open. Closing quit or calling Stop
+// shuts the worker down, which the program running it must do as the worker
+// does not handle the interrupt, and closing the returned connection ends the
+// RPC server.
+func NewInProcess(quit chan struct{}, open broadcast.Opener) (w *Worker,
+	conn net.Conn, err error) {
+	serverConn, clientConn := net.Pipe()
+	w = newWorker(nil, quit)
+	if open != nil {
+		w.open = open
+	}
+	// each worker has its own server as the default one can only hold one
+	// receiver of a name
+	server := rpc.NewServer()
+	if err = server.Register(w); err != nil {
+		log.L.Error(err)
+		w.quit()
+		return
+	}
+	go func() {
+		server.ServeConn(serverConn)
+		log.L.Trace("in-process worker IPC finished")
+	}()
+	return w, clientConn, nil
+}
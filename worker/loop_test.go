@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/p9c/chainhash"
+	"github.com/p9c/fork"
+	"github.com/p9c/util"
+	"github.com/p9c/wire"
+
+	blockchain "github.com/p9c/chain"
+)
+
+// benchBits is a target no benchmark hash will meet, so every hash is counted
+const benchBits = 0x1d00ffff
+
+// benchJob returns the height a hard fork activates on mainnet with the
+// versions used there and a job for them
+func benchJob(hf fork.HardForks) (height int32, versions []int32,
+	bitses blockchain.TargetBits, hashes map[int32]*chainhash.Hash) {
+	height = hf.ActivationHeight
+	bitses = make(blockchain.TargetBits, len(hf.AlgoVers))
+	hashes = make(map[int32]*chainhash.Hash, len(hf.AlgoVers))
+	for ver := range hf.AlgoVers {
+		versions = append(versions, ver)
+		bitses[ver] = benchBits
+		hashes[ver] = &chainhash.Hash{byte(ver)}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	return
+}
+
+func benchCounter(version int32) (c *Counter) {
+	c = NewCounter(RoundsPerAlgo)
+	c.Algos.Store([]int32{version})
+	return
+}
+
+// benchVersions runs a benchmark for each version of each hard fork. The
+// cost of the loop around the hash shows best on the cheap hashes from before
+// the first one.
+func benchVersions(b *testing.B, bench func(b *testing.B, height,
+	version int32, bitses blockchain.TargetBits,
+	hashes map[int32]*chainhash.Hash)) {
+	for _, hf := range fork.List {
+		height, versions, bitses, hashes := benchJob(hf)
+		for _, ver := range versions {
+			ver := ver
+			b.Run(fmt.Sprintf("%s-%d", fork.GetAlgoName(ver, height), ver),
+				func(b *testing.B) {
+					bench(b, height, ver, bitses, hashes)
+				})
+		}
+	}
+}
+
+// BenchmarkLoopShared hashes the way the hot loop did before snapshots,
+// reading the shared job state and copying the block for every hash
+func BenchmarkLoopShared(b *testing.B) {
+	benchVersions(b, func(b *testing.B, height, version int32,
+		bitses blockchain.TargetBits, hashes map[int32]*chainhash.Hash) {
+		// these are the shared job state the hot loop used to read
+		w := &struct {
+			roller                          *Counter
+			block, msgBlock, bitses, hashes atomic.Value
+		}{roller: benchCounter(version)}
+		mb := wire.MsgBlock{Header: wire.BlockHeader{Version: version,
+			MerkleRoot: *hashes[version], Bits: benchBits}}
+		bb := util.NewBlock(&mb)
+		bb.SetHeight(height)
+		w.block.Store(bb)
+		w.msgBlock.Store(mb)
+		w.bitses.Store(bitses)
+		w.hashes.Store(hashes)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			nH := w.block.Load().(*util.Block).Height()
+			hv := w.roller.GetAlgoVer()
+			h := w.hashes.Load().(map[int32]*chainhash.Hash)
+			mmb := w.msgBlock.Load().(wire.MsgBlock)
+			mb := &mmb
+			mb.Header.Version = hv
+			mb.Header.MerkleRoot = *h[hv]
+			mb.Header.Bits = w.bitses.Load().(blockchain.TargetBits)[hv]
+			hash := mb.Header.BlockHashWithAlgos(nH)
+			if blockchain.HashToBig(&hash).
+				Cmp(fork.CompactToBig(mb.Header.Bits)) <= 0 {
+				b.Fatal("found a solution to an impossible target")
+			}
+			mb.Header.Nonce++
+			w.msgBlock.Store(*mb)
+		}
+	})
+}
+
+// BenchmarkLoopSnapshot hashes the way the hot loop does, in batches on a
+// snapshot of the job
+func BenchmarkLoopSnapshot(b *testing.B) {
+	benchVersions(b, func(b *testing.B, height, version int32,
+		bitses blockchain.TargetBits, hashes map[int32]*chainhash.Hash) {
+		c := benchCounter(version)
+		s := newSnapshot(height, wire.BlockHeader{Version: version,
+			MerkleRoot: *hashes[version], Bits: benchBits}, bitses, hashes)
+		b.ResetTimer()
+		for i := 0; i < b.N; i += HashBatch {
+			n := int32(HashBatch)
+			if left := b.N - i; left < HashBatch {
+				n = int32(left)
+			}
+			ver := c.Take(n)
+			done, found := s.hashBatch(ver, n)
+			c.Done(ver, done)
+			if found {
+				b.Fatal("found a solution to an impossible target")
+			}
+		}
+		b.StopTimer()
+		if counts := c.TakeCounts(); counts[version] != int32(b.N) {
+			b.Fatalf("counted %d hashes, %d were done", counts[version], b.N)
+		}
+	})
+}
+
+// TestHashBatchWithoutWork checks no hashes are counted for a version the job
+// has no target for
+func TestHashBatchWithoutWork(t *testing.T) {
+	height, versions, bitses, hashes := benchJob(fork.List[len(fork.List)-1])
+	ver := versions[0]
+	delete(bitses, ver)
+	c := benchCounter(ver)
+	s := newSnapshot(height, wire.BlockHeader{Version: ver,
+		MerkleRoot: *hashes[ver]}, bitses, hashes)
+	taken := c.Take(HashBatch)
+	done, found := s.hashBatch(taken, HashBatch)
+	c.Done(taken, done)
+	if done != 0 || found {
+		t.Fatalf("hashed %d found %v on a version with no target", done, found)
+	}
+	if counts := c.TakeCounts(); len(counts) > 0 {
+		t.Fatalf("counted %v with no hashes done", counts)
+	}
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/p9c/chaincfg/netparams"
+	"github.com/p9c/chainhash"
+	"github.com/p9c/fork"
+	"github.com/p9c/wire"
+)
+
+// Vector is a block header and the proof of work hash it must give at a
+// height
+type Vector struct {
+	Name    string
+	Testnet bool
+	Height  int32
+	Header  wire.BlockHeader
+	Hash    chainhash.Hash
+}
+
+// Vectors are the known answers checked by SelfTest, the genesis blocks of
+// the chains with the hashes given in their parameters. The mainnet genesis
+// header is also checked as a block from before the hard fork, where its
+// version is hashed with SHA256d on mainnet but with a Plan 9 algorithm on
+// the testnet schedule, so a worker hashing for the wrong network fails.
+var Vectors = []Vector{
+	{
+		Name:   "mainnet genesis",
+		Header: netparams.MainNetParams.GenesisBlock.Header,
+		Hash:   *netparams.MainNetParams.GenesisHash,
+	},
+	{
+		Name:   "mainnet genesis before the hard fork",
+		Height: 1000,
+		Header: netparams.MainNetParams.GenesisBlock.Header,
+		Hash:   *netparams.MainNetParams.GenesisHash,
+	},
+	{
+		Name:    "testnet genesis",
+		Testnet: true,
+		Header:  netparams.TestNet3Params.GenesisBlock.Header,
+		Hash:    *netparams.TestNet3Params.GenesisHash,
+	},
+}
+
+// Testnet returns whether a network is mined on the testnet fork schedule,
+// which every network but mainnet is
+func Testnet(network string) bool {
+	return network != netparams.MainNetParams.Name
+}
+
+func scheduleName(testnet bool) string {
+	if testnet {
+		return "testnet"
+	}
+	return "mainnet"
+}
+
+// SelfTest checks the fork schedule set in fork.IsTestnet is the one of the
+// network the worker is expected to mine, and the hash functions give the
+// answers of the vectors of that network, returning an error listing every
+// failure
+func SelfTest(testnet bool) (err error) {
+	var failed []string
+	if fork.IsTestnet != testnet {
+		failed = append(failed, fmt.Sprintf("hashing on the %s schedule,"+
+			" expected %s", scheduleName(fork.IsTestnet),
+			scheduleName(testnet)))
+	}
+	for i := range Vectors {
+		v := &Vectors[i]
+		if v.Testnet != testnet {
+			continue
+		}
+		hash := v.Header.BlockHashWithAlgos(v.Height)
+		if !hash.IsEqual(&v.Hash) {
+			failed = append(failed, fmt.Sprintf("%s (%s) gave %s expected %s",
+				v.Name, fork.GetAlgoName(v.Header.Version, v.Height), hash,
+				v.Hash))
+		}
+	}
+	if len(failed) > 0 {
+		err = fmt.Errorf("hash self test failed: %s",
+			strings.Join(failed, "; "))
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"math/big"
+
+	"github.com/p9c/chainhash"
+	"github.com/p9c/fork"
+	"github.com/p9c/wire"
+
+	blockchain "github.com/p9c/chain"
+)
+
+// HashBatch is the number of hashes done between checks of the worker
+// channels
+const HashBatch = 16
+
+// snapshot is the worker's own copy of the current job, read from the shared
+// state once when the job changes so the hot loop touches nothing shared
+type snapshot struct {
+	height  int32
+	header  wire.BlockHeader
+	hashes  map[int32]*chainhash.Hash
+	bitses  blockchain.TargetBits
+	targets map[int32]*big.Int
+}
+
+func newSnapshot(height int32, header wire.BlockHeader,
+	bitses blockchain.TargetBits,
+	hashes map[int32]*chainhash.Hash) (s *snapshot) {
+	s = &snapshot{
+		height:  height,
+		header:  header,
+		hashes:  hashes,
+		bitses:  bitses,
+		targets: make(map[int32]*big.Int, len(bitses)),
+	}
+	for ver, bits := range bitses {
+		s.targets[ver] = fork.CompactToBig(bits)
+	}
+	return
+}
+
+// hashBatch does up to n hashes on a version, returning how many were done
+// and true with the header left on the solution if one is found. It does
+// none if the job has no work for the version.
+func (s *snapshot) hashBatch(ver int32, n int32) (done int32, found bool) {
+	target, ok := s.targets[ver]
+	if !ok {
+		return
+	}
+	if s.header.Version != ver {
+		mr, ok := s.hashes[ver]
+		if !ok {
+			return
+		}
+		s.header.Version = ver
+		s.header.MerkleRoot = *mr
+		s.header.Bits = s.bitses[ver]
+	}
+	for done < n {
+		hash := s.header.BlockHashWithAlgos(s.height)
+		done++
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			return done, true
+		}
+		s.header.Nonce++
+	}
+	return
+}
+
+// msgBlock returns a block with the header of the snapshot
+func (s *snapshot) msgBlock() *wire.MsgBlock {
+	return &wire.MsgBlock{Header: s.header}
+}
@@ -0,0 +1,339 @@
+package kopach
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"go.uber.org/atomic"
+
+	log "github.com/p9c/logi"
+
+	"github.com/p9c/stdconn/worker"
+
+	"github.com/p9c/kopach/client"
+	"github.com/p9c/kopach/kopachctrl/job"
+	"github.com/p9c/kopach/kopachctrl/settings"
+	kw "github.com/p9c/kopach/worker"
+)
+
+// workerProc is a worker and the client connected to it. The worker is a
+// child process in cmd, or with in-process workers a goroutine in local.
+type workerProc struct {
+	n      int
+	cmd    *worker.Worker
+	local  *kw.Worker
+	client *client.Client
+	// threads is the number of hashing goroutines the process runs
+	threads int32
+	// stopping is set when the worker is being stopped on purpose
+	stopping atomic.Bool
+}
+
+// clients returns the clients of the running workers
+func (w *Worker) clients() (out []*client.Client) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	for i := range w.procs {
+		out = append(out, w.procs[i].client)
+	}
+	return
+}
+
+// startWorker spawns a new worker process and brings it up to date with the
+// current password, settings and job
+func (w *Worker) startWorker() (err error) {
+	var p *workerProc
+	if p, err = w.runWorker(1); err != nil {
+		return
+	}
+	w.mx.Lock()
+	w.procs = append(w.procs, p)
+	w.mx.Unlock()
+	return nil
+}
+
+// runWorker spawns a worker with a number of hashing threads, checks it
+// hashes correctly and sets it up to mine
+func (w *Worker) runWorker(threads int32) (p *workerProc, err error) {
+	w.mx.Lock()
+	n := w.nextWorker
+	w.nextWorker++
+	s := w.settings
+	j := w.lastJob
+	held := w.held
+	w.mx.Unlock()
+	log.L.Debug("starting worker", n)
+	if p, err = w.spawn(n); err != nil {
+		return
+	}
+	p.threads = threads
+	// a worker that hashes wrongly would only waste its time
+	if err = p.client.SelfTest(kw.Testnet(w.network)); err != nil {
+		log.L.Error("worker", n, err)
+		w.stopWorker(p)
+		return nil, err
+	}
+	// collect the hashrate, solutions and state reports from the worker
+	go w.eventPump(p)
+	// a worker without the password or settings would never mine, so it is
+	// stopped for the caller to try again
+	if err = w.setUpWorker(p, s, j, held); err != nil {
+		log.L.Error("worker", n, err)
+		w.stopWorker(p)
+		return nil, err
+	}
+	return
+}
+
+// restartWorker replaces a worker that died with a new one running the same
+// number of threads, unless it was stopped or the miner stopped meanwhile
+func (w *Worker) restartWorker(p *workerProc) {
+	w.stopWorker(p)
+	// a worker that dies as it starts is not restarted at full speed
+	select {
+	case <-time.After(WorkerRestartDelay):
+	case <-w.quit:
+		return
+	}
+	if !w.active.Load() || !w.hasWorker(p) {
+		return
+	}
+	w.metrics.restarts.Inc()
+	np, err := w.runWorker(p.threads)
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	for i := range w.procs {
+		if w.procs[i] != p {
+			continue
+		}
+		if err != nil {
+			// the thread count is made up by the next call to SetThreads
+			log.L.Error("could not restart worker", p.n, err)
+			w.procs = append(w.procs[:i], w.procs[i+1:]...)
+		} else {
+			w.procs[i] = np
+		}
+		return
+	}
+	// the worker was removed while its replacement started
+	if err == nil {
+		go w.stopWorker(np)
+	}
+}
+
+// hasWorker returns whether a worker is one of the running workers
+func (w *Worker) hasWorker(p *workerProc) bool {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	for i := range w.procs {
+		if w.procs[i] == p {
+			return true
+		}
+	}
+	return false
+}
+
+// setUpWorker gives a new worker the password, its settings and the current
+// job if mining is not held
+func (w *Worker) setUpWorker(p *workerProc, s settings.Settings,
+	j *job.Container, held bool) (err error) {
+	log.L.Debug("sending pass to worker", p.n)
+	if err = p.client.SendPass(w.pass); err != nil {
+		return
+	}
+	if err = p.client.Configure(w.workerSettings(s, p.threads)); err != nil {
+		return
+	}
+	if j != nil && !held {
+		err = p.client.NewJob(j)
+	}
+	return
+}
+
+// spawn starts a worker as a child process, or in this process if the
+// kopach configuration asks for it, and connects a client to it
+func (w *Worker) spawn(n int) (p *workerProc, err error) {
+	p = &workerProc{n: n, threads: 1}
+	if w.cfg != nil && w.cfg.InProcess {
+		quit := make(chan struct{})
+		var conn net.Conn
+		if p.local, conn, err = kw.NewInProcess(quit, w.open); err != nil {
+			return
+		}
+		p.client = client.New(conn)
+		// the worker goes down with the miner
+		go func() {
+			select {
+			case <-w.quit:
+				var reply bool
+				if err := p.local.Stop(0, &reply); err != nil {
+					log.L.Error(err)
+				}
+			case <-quit:
+			}
+		}()
+		return
+	}
+	if p.cmd = worker.Spawn(os.Args[0], "worker",
+		w.network, w.logLevel); p.cmd == nil {
+		return nil, errWorkerSpawn
+	}
+	p.client = client.New(p.cmd.StdConn)
+	return
+}
+
+// stopWorker shuts down a worker
+func (w *Worker) stopWorker(p *workerProc) {
+	p.stopping.Store(true)
+	if p.local != nil {
+		if err := p.client.Stop(); err != nil {
+			log.L.Error(err)
+		}
+		if err := p.client.Close(); err != nil {
+			log.L.Error(err)
+		}
+	} else {
+		if err := p.cmd.Stop(); err != nil {
+			log.L.Error(err)
+		}
+		if err := p.cmd.Kill(); err != nil {
+			log.L.Error(err)
+		}
+		go func() {
+			if err := p.cmd.Wait(); err != nil {
+				log.L.Trace("worker", p.n, "exited", err)
+			}
+		}()
+	}
+	w.hashMx.Lock()
+	delete(w.mixes, p.n)
+	w.hashMx.Unlock()
+	log.L.Debug("stopped worker", p.n)
+}
+
+// SetThreads starts or stops workers until the given number of hashing
+// threads are running, spread over as few processes as the threads per
+// worker allow
+func (w *Worker) SetThreads(n int) (err error) {
+	if n < 0 {
+		n = 0
+	}
+	per := w.threadsPerWorker()
+	procs := (n + per - 1) / per
+	for {
+		w.mx.Lock()
+		running := len(w.procs)
+		var p *workerProc
+		if running > procs {
+			p = w.procs[running-1]
+			w.procs = w.procs[:running-1]
+		}
+		w.mx.Unlock()
+		switch {
+		case running < procs:
+			if err = w.startWorker(); err != nil {
+				log.L.Error(err)
+				return
+			}
+			continue
+		case p != nil:
+			w.stopWorker(p)
+			continue
+		}
+		break
+	}
+	// share the threads out between the processes
+	w.mx.Lock()
+	s := w.settings
+	var changed []*workerProc
+	for i, p := range w.procs {
+		threads := n - i*per
+		if threads > per {
+			threads = per
+		}
+		if p.threads != int32(threads) {
+			p.threads = int32(threads)
+			changed = append(changed, p)
+		}
+	}
+	w.identity.Threads = int32(n)
+	w.mx.Unlock()
+	for _, p := range changed {
+		if err = p.client.Configure(w.workerSettings(s,
+			p.threads)); err != nil {
+			log.L.Error(err)
+		}
+	}
+	return nil
+}
+
+// threadsPerWorker returns the most hashing threads run by one worker
+// process
+func (w *Worker) threadsPerWorker() int {
+	if w.cfg == nil || w.cfg.ThreadsPerWorker < 1 {
+		return 1
+	}
+	return w.cfg.ThreadsPerWorker
+}
+
+// stopWorkers shuts down all of the workers
+func (w *Worker) stopWorkers() {
+	w.mx.Lock()
+	procs := w.procs
+	w.procs = nil
+	w.mx.Unlock()
+	for i := range procs {
+		w.stopWorker(procs[i])
+	}
+}
+
+// pauseWorkers stops all workers working on the current job
+func (w *Worker) pauseWorkers() {
+	for _, c := range w.clients() {
+		if err := c.Pause(); err != nil {
+			log.L.Error(err)
+		}
+	}
+}
+
+// resumeWorkers gives all workers the latest job and sets them working
+func (w *Worker) resumeWorkers() {
+	w.mx.Lock()
+	j := w.lastJob
+	w.mx.Unlock()
+	if j == nil {
+		return
+	}
+	for _, c := range w.clients() {
+		if err := c.NewJob(j); err != nil {
+			log.L.Error(err)
+		}
+		if err := c.Resume(); err != nil {
+			log.L.Error(err)
+		}
+	}
+}
+
+// configureWorkers sends the worker settings to all the workers
+func (w *Worker) configureWorkers(s settings.Settings) {
+	w.mx.Lock()
+	procs := append([]*workerProc{}, w.procs...)
+	w.mx.Unlock()
+	for _, p := range procs {
+		if err := p.client.Configure(w.workerSettings(s,
+			p.threads)); err != nil {
+			log.L.Error(err)
+		}
+	}
+}
+
+// workerSettings combines the settings from the controller with the rotation
+// and algorithms from the kopach configuration and the threads of a worker
+func (w *Worker) workerSettings(s settings.Settings,
+	threads int32) *kw.Settings {
+	ws := w.rotation
+	ws.Algos = w.cfg.EffectiveAlgos(s.Algos)
+	ws.Threads = threads
+	return &ws
+}